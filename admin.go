@@ -0,0 +1,83 @@
+package main
+
+import (
+    "net/http"
+    "encoding/json"
+    "strings"
+    "context"
+    "log/slog"
+    "fmt"
+)
+
+func writeAdminResponse(w http.ResponseWriter, status int, payload interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    err := json.NewEncoder(w).Encode(payload)
+    if err != nil {
+        slog.Warn("failed to write the admin response", "error", err)
+    }
+}
+
+// Error responses use the same format as SewerRat, i.e., a JSON object with 'status' and 'reason' properties.
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+    writeAdminResponse(w, status, map[string]string{ "status": "ERROR", "reason": err.Error() })
+}
+
+func writeAdminSuccess(w http.ResponseWriter) {
+    writeAdminResponse(w, http.StatusOK, map[string]string{ "status": "SUCCESS" })
+}
+
+// Project and asset names cannot contain path separators or start with '..', as the latter are reserved for the Gobbler's internal files.
+func isValidRegistryName(name string) bool {
+    return name != "" && !strings.HasPrefix(name, "..") && !strings.ContainsAny(name, "/\\")
+}
+
+// Creates a handler for the admin API.
+// All operations use 'ctx' rather than the request's context, so that they are not interrupted if the client disconnects.
+func newAdminHandler(ctx context.Context, d *daemon) http.Handler {
+    mux := http.NewServeMux()
+
+    reconcileHandler := func(w http.ResponseWriter, r *http.Request) {
+        target := reconcileTarget{
+            Project: r.PathValue("project"),
+            Asset: r.PathValue("asset"),
+            Force: r.URL.Query().Get("force") == "true",
+        }
+        if !isValidRegistryName(target.Project) || (target.Asset != "" && !isValidRegistryName(target.Asset)) {
+            writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid project or asset name in %q", target.String()))
+            return
+        }
+        err := d.reconcile(ctx, target)
+        if err != nil {
+            writeAdminError(w, http.StatusInternalServerError, err)
+            return
+        }
+        writeAdminSuccess(w)
+    }
+    mux.HandleFunc("POST /reconcile/{project}", reconcileHandler)
+    mux.HandleFunc("POST /reconcile/{project}/{asset}", reconcileHandler)
+
+    mux.HandleFunc("POST /scan/logs", func(w http.ResponseWriter, r *http.Request) {
+        _, _, err := d.checkLogs(ctx)
+        if err != nil {
+            writeAdminError(w, http.StatusInternalServerError, err)
+            return
+        }
+        writeAdminSuccess(w)
+    })
+
+    mux.HandleFunc("POST /scan/full", func(w http.ResponseWriter, r *http.Request) {
+        err := d.fullScan(ctx)
+        if err != nil {
+            writeAdminError(w, http.StatusInternalServerError, err)
+            return
+        }
+        writeAdminSuccess(w)
+    })
+
+    mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+        writeAdminResponse(w, http.StatusOK, d.getStatus())
+    })
+
+    return mux
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestIsValidRegistryName(t *testing.T) {
+    for _, name := range []string{ "foo", "foo.bar", "foo..bar" } {
+        if !isValidRegistryName(name) {
+            t.Errorf("expected %q to be a valid name", name)
+        }
+    }
+    for _, name := range []string{ "", "..", "..logs", "foo/bar", "foo\\bar" } {
+        if isValidRegistryName(name) {
+            t.Errorf("expected %q to be an invalid name", name)
+        }
+    }
+}
+
+func TestAdminHandler(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    err = os.MkdirAll(filepath.Join(registry, "foo", "bar", "1"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(registry, "foo", "bar", "..latest"), []byte("{ \"version\": \"1\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Mkdir(filepath.Join(registry, "..logs"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    ledger, err := openLogLedger(filepath.Join(workdir, "ledger"), time.Now())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ledger.close()
+    retries, err := openRetryQueue(filepath.Join(workdir, "retries"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := getSewerRatClient()
+    d := &daemon{
+        Client: client,
+        Registry: registry,
+        Config: newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{}),
+        Ledger: ledger,
+        Retries: retries,
+        Concurrency: 1,
+    }
+
+    server := httptest.NewServer(newAdminHandler(context.Background(), d))
+    defer server.Close()
+
+    post := func(endpoint string) (int, map[string]string) {
+        resp, err := http.Post(server.URL + endpoint, "application/json", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer resp.Body.Close()
+        output := map[string]string{}
+        err = json.NewDecoder(resp.Body).Decode(&output)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return resp.StatusCode, output
+    }
+
+    // Reconciling a single asset.
+    {
+        code, payload := post("/reconcile/foo/bar")
+        if code != http.StatusOK || payload["status"] != "SUCCESS" {
+            t.Fatalf("unexpected response for reconciliation; %v", payload)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 1 || found[0] != "foo/bar/1" {
+            t.Errorf("unexpected registered directories after reconciliation; %v", found)
+        }
+    }
+
+    // Reconciling a deleted project.
+    {
+        err := os.RemoveAll(filepath.Join(registry, "foo"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        code, payload := post("/reconcile/foo")
+        if code != http.StatusOK {
+            t.Fatalf("unexpected response for reconciliation; %v", payload)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 0 {
+            t.Errorf("unexpected registered directories after reconciliation; %v", found)
+        }
+
+        code, payload = post("/reconcile/..logs/bar")
+        if code != http.StatusBadRequest || !strings.Contains(payload["reason"], "invalid") {
+            t.Errorf("expected an error for an invalid project name; %v", payload)
+        }
+    }
+
+    // Running scans and checking the status.
+    {
+        code, payload := post("/scan/logs")
+        if code != http.StatusOK {
+            t.Fatalf("unexpected response for a log scan; %v", payload)
+        }
+        code, payload = post("/scan/full")
+        if code != http.StatusOK {
+            t.Fatalf("unexpected response for a full scan; %v", payload)
+        }
+
+        resp, err := http.Get(server.URL + "/status")
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer resp.Body.Close()
+        status := daemonStatus{}
+        err = json.NewDecoder(resp.Body).Decode(&status)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if status.Logs.Running || status.Logs.LastSucceeded == nil || status.Full.LastSucceeded == nil || status.Full.LastError != "" {
+            t.Errorf("unexpected status; %v", status)
+        }
+
+        // Failures are reported in the status.
+        err = os.RemoveAll(filepath.Join(registry, "..logs"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        code, payload = post("/scan/logs")
+        if code != http.StatusInternalServerError || payload["status"] != "ERROR" {
+            t.Errorf("expected an error for a missing log directory; %v", payload)
+        }
+        status = d.getStatus()
+        if status.Logs.LastError == "" || !status.Logs.LastFinished.After(*(status.Logs.LastSucceeded)) {
+            t.Errorf("expected a failure in the status; %v", status)
+        }
+    }
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "os"
+    "io"
+    "path/filepath"
+    "strconv"
+    "testing"
+    "time"
+    "github.com/ArtifactDB/sayoko/gobblertest"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+    "github.com/ArtifactDB/sayoko/sewerrat/sewerrattest"
+)
+
+// Multiplier for the size of the synthetic registries in the benchmarks, from the SAYOKO_BENCH_SCALE environment variable.
+// This defaults to 1, i.e., 1000 assets and 10000 logs; larger values (e.g., 100) can be used to check behavior at production scale.
+func getBenchScale(b *testing.B) int {
+    val := os.Getenv("SAYOKO_BENCH_SCALE")
+    if val == "" {
+        return 1
+    }
+    scale, err := strconv.Atoi(val)
+    if err != nil || scale < 1 {
+        b.Fatalf("invalid SAYOKO_BENCH_SCALE %q", val)
+    }
+    return scale
+}
+
+// Synthetic registries are cached across benchmarks as they are expensive to create at scale.
+// These are removed by removeBenchRegistries() once all benchmarks are finished.
+var benchRegistries = map[string]*gobblertest.Registry{}
+
+func removeBenchRegistries() {
+    for key, reg := range benchRegistries {
+        os.RemoveAll(reg.Path)
+        delete(benchRegistries, key)
+    }
+}
+
+func getBenchRegistry(b *testing.B, projects int, assets int, versions int) *gobblertest.Registry {
+    key := fmt.Sprintf("%d-%d-%d", projects, assets, versions)
+    if reg, ok := benchRegistries[key]; ok {
+        return reg
+    }
+
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        b.Fatal(err)
+    }
+    reg, err := gobblertest.Create(dir, gobblertest.GenerateSpec(projects, assets, versions))
+    if err != nil {
+        b.Fatal(err)
+    }
+    benchRegistries[key] = reg
+    return reg
+}
+
+// Silences the logging in the benchmarked functions, to avoid measuring the cost of writing to the terminal.
+func silenceLogs(b *testing.B) {
+    previous := slog.Default()
+    slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+    b.Cleanup(func() { slog.SetDefault(previous) })
+}
+
+func reportRequests(b *testing.B, server *sewerrattest.Server, before int) {
+    b.ReportMetric(float64(server.Requests("") - before) / float64(b.N), "requests/op")
+}
+
+func BenchmarkFullScan(b *testing.B) {
+    silenceLogs(b)
+    scale := getBenchScale(b)
+    reg := getBenchRegistry(b, 10 * scale, 100, 2)
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+    ctx := context.Background()
+
+    // Registering all assets from scratch, e.g., upon the first deployment.
+    b.Run("initial", func(b *testing.B) {
+        b.ReportAllocs()
+        requests := 0
+        for i := 0; i < b.N; i++ {
+            b.StopTimer()
+            server := sewerrattest.NewServer()
+            b.StartTimer()
+
+            err := fullScan(ctx, sewerrat.NewClient(server.URL), reg.Path, config, 4)
+            if err != nil {
+                b.Fatal(err)
+            }
+
+            b.StopTimer()
+            requests += server.Requests("")
+            server.Close()
+            b.StartTimer()
+        }
+        b.ReportMetric(float64(requests) / float64(b.N), "requests/op")
+    })
+
+    // Checking a registry where everything is already registered, i.e., the usual weekly scan.
+    b.Run("steady", func(b *testing.B) {
+        server := sewerrattest.NewServer()
+        defer server.Close()
+        client := sewerrat.NewClient(server.URL)
+        err := fullScan(ctx, client, reg.Path, config, 4)
+        if err != nil {
+            b.Fatal(err)
+        }
+
+        b.ReportAllocs()
+        before := server.Requests("")
+        b.ResetTimer()
+        for i := 0; i < b.N; i++ {
+            err := fullScan(ctx, client, reg.Path, config, 4)
+            if err != nil {
+                b.Fatal(err)
+            }
+        }
+        b.StopTimer()
+        reportRequests(b, server, before)
+    })
+}
+
+// Creates a registry with 'count' logs, each of which adds a new version to one of a handful of assets.
+func getBenchLogRegistry(b *testing.B, count int) *gobblertest.Registry {
+    key := fmt.Sprintf("logs-%d", count)
+    if reg, ok := benchRegistries[key]; ok {
+        return reg
+    }
+
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        b.Fatal(err)
+    }
+    reg, err := gobblertest.Create(dir, gobblertest.GenerateSpec(1, 10, 1))
+    if err != nil {
+        b.Fatal(err)
+    }
+    reg.Clock = time.Date(2022, 2, 22, 2, 22, 22, 0, time.UTC)
+    for i := 0; i < count; i++ {
+        _, err := reg.WriteLog(map[string]interface{}{ "type": "add-version", "project": "project-0", "asset": fmt.Sprintf("asset-%d", i % 10), "version": "1" })
+        if err != nil {
+            b.Fatal(err)
+        }
+    }
+
+    benchRegistries[key] = reg
+    return reg
+}
+
+func BenchmarkProcessLogs(b *testing.B) {
+    silenceLogs(b)
+    scale := getBenchScale(b)
+    reg := getBenchLogRegistry(b, 10000 * scale)
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+    ctx := context.Background()
+
+    server := sewerrattest.NewServer()
+    defer server.Close()
+    client := sewerrat.NewClient(server.URL)
+
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        b.Fatal(err)
+    }
+    b.Cleanup(func() { os.RemoveAll(workdir) })
+    retries, err := openRetryQueue(filepath.Join(workdir, "retries"))
+    if err != nil {
+        b.Fatal(err)
+    }
+
+    // Processing every log with a fresh ledger.
+    b.Run("new", func(b *testing.B) {
+        b.ReportAllocs()
+        before := server.Requests("")
+        for i := 0; i < b.N; i++ {
+            b.StopTimer()
+            ledger_path := filepath.Join(workdir, "ledger-new")
+            os.Remove(ledger_path)
+            ledger, err := openLogLedger(ledger_path, time.Time{})
+            if err != nil {
+                b.Fatal(err)
+            }
+            b.StartTimer()
+
+            err = processLogs(ctx, client, reg.Path, config, ledger, retries)
+            if err != nil {
+                b.Fatal(err)
+            }
+
+            b.StopTimer()
+            ledger.close()
+            b.StartTimer()
+        }
+        b.StopTimer()
+        reportRequests(b, server, before)
+    })
+
+    // Scanning a log directory where all logs have already been processed, i.e., the usual log check.
+    b.Run("processed", func(b *testing.B) {
+        ledger, err := openLogLedger(filepath.Join(workdir, "ledger-processed"), time.Time{})
+        if err != nil {
+            b.Fatal(err)
+        }
+        defer ledger.close()
+        err = processLogs(ctx, client, reg.Path, config, ledger, retries)
+        if err != nil {
+            b.Fatal(err)
+        }
+
+        b.ReportAllocs()
+        before := server.Requests("")
+        b.ResetTimer()
+        for i := 0; i < b.N; i++ {
+            err := processLogs(ctx, client, reg.Path, config, ledger, retries)
+            if err != nil {
+                b.Fatal(err)
+            }
+        }
+        b.StopTimer()
+        reportRequests(b, server, before)
+    })
+}
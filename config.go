@@ -0,0 +1,170 @@
+package main
+
+import (
+    "os"
+    "path"
+    "encoding/json"
+    "fmt"
+)
+
+// An indexPolicy describes how the versions of an asset should be indexed by SewerRat.
+type indexPolicy struct {
+    // Names of the metadata files to be indexed.
+    Names []string
+
+    // Policy for choosing the versions to be registered.
+    Retention retentionPolicy
+
+    // Whether the asset should be excluded from the index altogether, in which case all of its versions are deregistered.
+    Exclude bool
+
+    // Whether to check that a version contains at least one of the metadata files before registering it.
+    CheckNames bool
+}
+
+type policyRule struct {
+    Project string
+    Asset string
+    Policy indexPolicy
+}
+
+// An indexConfig maps projects and assets to their index policies.
+// Assets that are not allowed by the filter are always excluded.
+// For all other assets, the first rule with matching project and asset patterns is used; if no rules match, the default is used.
+type indexConfig struct {
+    Default indexPolicy
+    Rules []policyRule
+    Filter nameFilter
+}
+
+func newIndexConfig(names []string, retention retentionPolicy) *indexConfig {
+    return &indexConfig{
+        Default: indexPolicy{
+            Names: names,
+            Retention: retention,
+        },
+    }
+}
+
+func (c *indexConfig) lookup(project, asset string) indexPolicy {
+    if !c.Filter.allows(project, asset) {
+        return indexPolicy{ Exclude: true }
+    }
+
+    for _, rule := range c.Rules {
+        // Patterns were already validated when the rules were loaded, so we can ignore errors here.
+        if matched, _ := path.Match(rule.Project, project); !matched {
+            continue
+        }
+        if matched, _ := path.Match(rule.Asset, asset); !matched {
+            continue
+        }
+        return rule.Policy
+    }
+    return c.Default
+}
+
+// Whether all assets in a project are excluded, either by the filter or by a rule that matches all assets in the project.
+func (c *indexConfig) excludesProject(project string) bool {
+    if c.Filter.excludesProject(project) {
+        return true
+    }
+
+    for _, rule := range c.Rules {
+        if matched, _ := path.Match(rule.Project, project); !matched {
+            continue
+        }
+        if rule.Asset == "*" {
+            return rule.Policy.Exclude
+        }
+        if !rule.Policy.Exclude { // some assets in this project might be indexed.
+            return false
+        }
+    }
+
+    return c.Default.Exclude
+}
+
+type configFileRule struct {
+    Project string `json:"project"`
+    Asset string `json:"asset"`
+    Names []string `json:"names"`
+    Retention string `json:"retention"`
+    Exclude bool `json:"exclude"`
+}
+
+type configFile struct {
+    Include []string `json:"include"`
+    Exclude []string `json:"exclude"`
+    Rules []configFileRule `json:"rules"`
+}
+
+// Adds rules and filter patterns from a JSON configuration file to the existing configuration.
+// Fields that are not specified in a rule are set to the corresponding values of the default policy.
+func (c *indexConfig) loadConfigFile(config_path string) error {
+    handle, err := os.Open(config_path)
+    if err != nil {
+        return fmt.Errorf("failed to open %q; %w", config_path, err)
+    }
+    defer handle.Close()
+
+    dec := json.NewDecoder(handle)
+    dec.DisallowUnknownFields()
+    contents := configFile{}
+    err = dec.Decode(&contents)
+    if err != nil {
+        return fmt.Errorf("failed to parse %q; %w", config_path, err)
+    }
+
+    include, err := parseNamePatterns(contents.Include)
+    if err != nil {
+        return fmt.Errorf("invalid include pattern in %q; %w", config_path, err)
+    }
+    c.Filter.Include = append(c.Filter.Include, include...)
+
+    exclude, err := parseNamePatterns(contents.Exclude)
+    if err != nil {
+        return fmt.Errorf("invalid exclude pattern in %q; %w", config_path, err)
+    }
+    c.Filter.Exclude = append(c.Filter.Exclude, exclude...)
+
+    for i, rule := range contents.Rules {
+        converted := policyRule{
+            Project: rule.Project,
+            Asset: rule.Asset,
+            Policy: c.Default,
+        }
+
+        if converted.Project == "" {
+            converted.Project = "*"
+        }
+        if _, err := path.Match(converted.Project, ""); err != nil {
+            return fmt.Errorf("invalid project pattern %q in rule %d of %q; %w", converted.Project, i, config_path, err)
+        }
+        if converted.Asset == "" {
+            converted.Asset = "*"
+        }
+        if _, err := path.Match(converted.Asset, ""); err != nil {
+            return fmt.Errorf("invalid asset pattern %q in rule %d of %q; %w", converted.Asset, i, config_path, err)
+        }
+
+        if rule.Names != nil {
+            if len(rule.Names) == 0 {
+                return fmt.Errorf("empty metadata file names in rule %d of %q", i, config_path)
+            }
+            converted.Policy.Names = rule.Names
+        }
+        if rule.Retention != "" {
+            retention, err := parseRetentionPolicy(rule.Retention)
+            if err != nil {
+                return fmt.Errorf("invalid retention policy in rule %d of %q; %w", i, config_path, err)
+            }
+            converted.Policy.Retention = retention
+        }
+        converted.Policy.Exclude = rule.Exclude
+
+        c.Rules = append(c.Rules, converted)
+    }
+
+    return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "strings"
+)
+
+func TestIndexConfigLookup(t *testing.T) {
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+    config.Rules = append(config.Rules, policyRule{
+        Project: "scratch-*",
+        Asset: "*",
+        Policy: indexPolicy{ Exclude: true },
+    })
+    config.Rules = append(config.Rules, policyRule{
+        Project: "reference",
+        Asset: "*",
+        Policy: indexPolicy{ Names: []string{ "metadata.json", "annotations.json" }, Retention: allVersionsRetention{} },
+    })
+
+    found := config.lookup("scratch-aaron", "foo")
+    if !found.Exclude {
+        t.Error("expected scratch project to be excluded")
+    }
+
+    found = config.lookup("reference", "foo")
+    if found.Exclude || len(found.Names) != 2 || found.Names[1] != "annotations.json" {
+        t.Errorf("unexpected policy for the reference project; %v", found)
+    }
+    if _, ok := found.Retention.(allVersionsRetention); !ok {
+        t.Error("expected an all-versions policy for the reference project")
+    }
+
+    found = config.lookup("whee", "foo")
+    if found.Exclude || len(found.Names) != 1 || found.Names[0] != "metadata.json" {
+        t.Errorf("unexpected default policy; %v", found)
+    }
+    if _, ok := found.Retention.(latestOnlyRetention); !ok {
+        t.Error("expected a latest-only policy by default")
+    }
+}
+
+func TestIndexConfigLoadConfigFile(t *testing.T) {
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create working directory; %v", err)
+    }
+    config_path := filepath.Join(workdir, "config.json")
+
+    err = os.WriteFile(config_path, []byte(`{
+    "rules": [
+        { "project": "scratch-*", "exclude": true },
+        { "project": "reference", "asset": "gene*", "names": [ "metadata.json", "annotations.json" ] },
+        { "project": "reference", "retention": "latest:3" }
+    ]
+}`), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+    err = config.loadConfigFile(config_path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(config.Rules) != 3 {
+        t.Fatalf("unexpected number of rules; %v", config.Rules)
+    }
+
+    found := config.lookup("scratch-123", "foo")
+    if !found.Exclude {
+        t.Error("expected scratch project to be excluded")
+    }
+
+    found = config.lookup("reference", "genes")
+    if found.Exclude || len(found.Names) != 2 || found.Names[1] != "annotations.json" {
+        t.Errorf("unexpected policy for the reference genes; %v", found)
+    }
+    if _, ok := found.Retention.(latestOnlyRetention); !ok {
+        t.Error("expected the default retention policy for the reference genes")
+    }
+
+    found = config.lookup("reference", "cells")
+    if found.Exclude || len(found.Names) != 1 || found.Names[0] != "metadata.json" {
+        t.Errorf("unexpected policy for the reference cells; %v", found)
+    }
+    if casted, ok := found.Retention.(latestCountRetention); !ok || casted.Count != 3 {
+        t.Error("expected a latest-N policy for the reference cells")
+    }
+
+    // Checking for failures.
+    for _, failure := range []struct{ Contents string; Message string }{
+        { Contents: `{ "rules": [ { "project": "[" } ] }`, Message: "invalid project" },
+        { Contents: `{ "rules": [ { "asset": "[" } ] }`, Message: "invalid asset" },
+        { Contents: `{ "rules": [ { "retention": "whee" } ] }`, Message: "invalid retention" },
+        { Contents: `{ "rules": [ { "names": [] } ] }`, Message: "empty metadata" },
+        { Contents: `{ "rules": [ { "foo": "bar" } ] }`, Message: "unknown field" },
+    } {
+        err = os.WriteFile(config_path, []byte(failure.Contents), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{}).loadConfigFile(config_path)
+        if err == nil || !strings.Contains(err.Error(), failure.Message) {
+            t.Errorf("expected an error containing %q; %v", failure.Message, err)
+        }
+    }
+}
+
+func TestIndexConfigFilter(t *testing.T) {
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+    exclude, err := parseNamePatterns([]string{ "sandbox-*" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    config.Filter.Exclude = exclude
+
+    if !config.lookup("sandbox-foo", "bar").Exclude || !config.excludesProject("sandbox-foo") {
+        t.Error("expected the filter to exclude the sandbox project")
+    }
+    if config.lookup("foo", "bar").Exclude || config.excludesProject("foo") {
+        t.Error("expected other projects to be included")
+    }
+
+    // Rules can also exclude an entire project.
+    config.Rules = append(config.Rules, policyRule{ Project: "test-*", Asset: "keep", Policy: config.Default })
+    config.Rules = append(config.Rules, policyRule{ Project: "test-*", Asset: "*", Policy: indexPolicy{ Exclude: true } })
+    config.Rules = append(config.Rules, policyRule{ Project: "scratch", Asset: "*", Policy: indexPolicy{ Exclude: true } })
+    if config.excludesProject("test-foo") || !config.lookup("test-foo", "bar").Exclude || config.lookup("test-foo", "keep").Exclude {
+        t.Error("expected a project with a retained asset to be included")
+    }
+    if !config.excludesProject("scratch") {
+        t.Error("expected a project excluded by a rule to be excluded")
+    }
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "time"
+    "sync"
+    "context"
+    "log/slog"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+// Status of a recurring operation, i.e., log checks or full scans.
+type operationStatus struct {
+    Running bool `json:"running"`
+    LastStarted *time.Time `json:"last_started,omitempty"`
+    LastFinished *time.Time `json:"last_finished,omitempty"`
+    LastSucceeded *time.Time `json:"last_succeeded,omitempty"`
+    LastError string `json:"last_error,omitempty"`
+    last_succeeded_start time.Time
+}
+
+type daemonStatus struct {
+    Logs operationStatus `json:"logs"`
+    Full operationStatus `json:"full"`
+    PendingRetries int `json:"pending_retries"`
+    newest_log time.Time // timestamp of the newest log seen by the last log check.
+}
+
+// A daemon holds the state that is shared between the timers in main() and the admin API.
+// All operations that (de)register directories or modify the ledger or retry queue are serialized by the daemon's lock.
+type daemon struct {
+    Client *sewerrat.Client
+    Registry string
+    Config *indexConfig
+    Ledger *logLedger
+    Retries *retryQueue
+    Concurrency int
+
+    lock sync.Mutex
+
+    // The status is protected by a separate lock so that it can be reported while an operation is running.
+    status daemonStatus
+    status_lock sync.Mutex
+}
+
+func (d *daemon) startOperation(op *operationStatus) {
+    d.status_lock.Lock()
+    defer d.status_lock.Unlock()
+    now := time.Now()
+    op.Running = true
+    op.LastStarted = &now
+}
+
+func (d *daemon) finishOperation(op *operationStatus, err error) {
+    pending := len(d.Retries.Entries) // the main lock is still held by the caller.
+    d.status_lock.Lock()
+    defer d.status_lock.Unlock()
+    now := time.Now()
+    op.Running = false
+    op.LastFinished = &now
+    if err == nil {
+        op.LastSucceeded = &now
+        op.last_succeeded_start = *(op.LastStarted)
+        op.LastError = ""
+    } else {
+        op.LastError = err.Error()
+    }
+    d.status.PendingRetries = pending
+}
+
+// Current status of the daemon's operations.
+func (d *daemon) getStatus() daemonStatus {
+    d.status_lock.Lock()
+    defer d.status_lock.Unlock()
+    return d.status
+}
+
+// Performs a log check, returning the time of the next retry (if any).
+func (d *daemon) checkLogs(ctx context.Context) (time.Time, bool, error) {
+    d.lock.Lock()
+    defer d.lock.Unlock()
+    start := time.Now()
+    d.startOperation(&(d.status.Logs))
+    err := checkLogs(ctx, d.Client, d.Registry, d.Config, d.Ledger, d.Retries)
+    d.status_lock.Lock()
+    d.status.newest_log = d.Ledger.Newest
+    d.status_lock.Unlock()
+    d.finishOperation(&(d.status.Logs), err)
+    slog.Debug("finished log check", "duration", time.Since(start), "failures", countFailures(err))
+    metricLogCheckDuration.Observe(time.Since(start).Seconds())
+    if err == nil {
+        metricLastLogCheck.Set(float64(start.Unix()))
+    }
+    next_retry, has_retry := d.Retries.nextDue()
+    return next_retry, has_retry, err
+}
+
+func (d *daemon) fullScan(ctx context.Context) error {
+    d.lock.Lock()
+    defer d.lock.Unlock()
+    start := time.Now()
+    d.startOperation(&(d.status.Full))
+    slog.Info("starting full scan")
+    err := fullScan(ctx, d.Client, d.Registry, d.Config, d.Concurrency)
+    d.finishOperation(&(d.status.Full), err)
+    slog.Info("finished full scan", "duration", time.Since(start), "failures", countFailures(err))
+    metricFullScanDuration.Observe(time.Since(start).Seconds())
+    if err == nil {
+        metricLastFullScan.Set(float64(start.Unix()))
+    }
+    return err
+}
+
+func (d *daemon) reconcile(ctx context.Context, target reconcileTarget) error {
+    d.lock.Lock()
+    defer d.lock.Unlock()
+    return reconcile(ctx, d.Client, d.Registry, d.Config, target)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "sort"
+    "strings"
+    "encoding/json"
+    "text/tabwriter"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+// Prints the actions in the dry-run plan, sorted by path, in the specified format (i.e., "table" or "json").
+func printPlan(w io.Writer, plan *sewerrat.Plan, format string) error {
+    actions := plan.Actions()
+    sort.SliceStable(actions, func(i, j int) bool {
+        return actions[i].Path < actions[j].Path
+    })
+
+    if format == "json" {
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "    ")
+        err := enc.Encode(actions)
+        if err != nil {
+            return fmt.Errorf("failed to print the plan as JSON; %w", err)
+        }
+        return nil
+    }
+
+    if format != "table" {
+        return fmt.Errorf("unknown format %q for printing the plan", format)
+    }
+
+    tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(tw, "ACTION\tPATH\tNAMES")
+    counts := map[string]int{}
+    for _, action := range actions {
+        names := "-"
+        if action.Names != nil {
+            names = strings.Join(action.Names, ",")
+        }
+        fmt.Fprintf(tw, "%s\t%s\t%s\n", action.Type, action.Path, names)
+        counts[action.Type]++
+    }
+    err := tw.Flush()
+    if err != nil {
+        return fmt.Errorf("failed to print the plan as a table; %w", err)
+    }
+
+    _, err = fmt.Fprintf(w, "\n%d to register, %d to reindex, %d to deregister\n", counts[sewerrat.ActionRegister], counts[sewerrat.ActionReindex], counts[sewerrat.ActionDeregister])
+    return err
+}
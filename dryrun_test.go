@@ -0,0 +1,110 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+func TestDryRun(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    for _, ver := range []string{ "1", "2" } {
+        err = os.MkdirAll(filepath.Join(registry, "foo", "bar", ver), 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    err = os.WriteFile(filepath.Join(registry, "foo", "bar", "..latest"), []byte("{ \"version\": \"2\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := getSewerRatClient()
+    names := []string{ "metadata.json" }
+    err = client.RegisterDirectory(context.Background(), filepath.Join(registry, "foo", "bar", "1"), names)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    config := newIndexConfig(names, latestOnlyRetention{})
+    client.DryRun = &sewerrat.Plan{}
+    err = fullScan(context.Background(), client, registry, config, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    actions := client.DryRun.Actions()
+    if len(actions) != 2 ||
+        actions[0].Type != sewerrat.ActionDeregister || actions[0].Path != filepath.Join(registry, "foo", "bar", "1") ||
+        actions[1].Type != sewerrat.ActionRegister || actions[1].Path != filepath.Join(registry, "foo", "bar", "2") {
+        t.Errorf("unexpected actions in the plan; %v", actions)
+    }
+
+    // Nothing was actually changed.
+    found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0] != "foo/bar/1" {
+        t.Errorf("dry run should not modify the registered directories; %v", found)
+    }
+
+    // Forced reindexing is reported separately.
+    {
+        client.DryRun = &sewerrat.Plan{}
+        err := ignoreNonLatest(context.Background(), client, filepath.Join(registry, "foo", "bar"), indexPolicy{ Names: names, Retention: allowListRetention{ Versions: []string{ "1", "2" } } }, true)
+        if err != nil {
+            t.Fatal(err)
+        }
+        actions := client.DryRun.Actions()
+        sort.Slice(actions, func(i, j int) bool { return actions[i].Path < actions[j].Path })
+        if len(actions) != 2 || actions[0].Type != sewerrat.ActionReindex || actions[1].Type != sewerrat.ActionRegister {
+            t.Errorf("unexpected actions in the plan; %v", actions)
+        }
+    }
+
+    // Printing the plan.
+    {
+        var buf bytes.Buffer
+        err := printPlan(&buf, client.DryRun, "table")
+        if err != nil {
+            t.Fatal(err)
+        }
+        lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+        if len(lines) != 5 || !strings.HasPrefix(lines[0], "ACTION") || !strings.HasPrefix(lines[1], "reindex") || !strings.HasPrefix(lines[2], "register") {
+            t.Errorf("unexpected table for the plan; %s", buf.String())
+        }
+        if lines[4] != "1 to register, 1 to reindex, 0 to deregister" {
+            t.Errorf("unexpected summary for the plan; %s", lines[4])
+        }
+
+        buf.Reset()
+        err = printPlan(&buf, client.DryRun, "json")
+        if err != nil {
+            t.Fatal(err)
+        }
+        decoded := []sewerrat.Action{}
+        err = json.Unmarshal(buf.Bytes(), &decoded)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(decoded) != 2 || decoded[0].Type != sewerrat.ActionReindex || decoded[1].Names[0] != "metadata.json" {
+            t.Errorf("unexpected JSON for the plan; %s", buf.String())
+        }
+
+        err = printPlan(&buf, client.DryRun, "yaml")
+        if err == nil || !strings.Contains(err.Error(), "unknown format") {
+            t.Error("expected an error for an unknown format")
+        }
+    }
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+    "path"
+    "regexp"
+    "strings"
+    "fmt"
+)
+
+type nameMatcher struct {
+    Glob string
+    Regexp *regexp.Regexp
+}
+
+func (m nameMatcher) matches(name string) bool {
+    if m.Regexp != nil {
+        return m.Regexp.MatchString(name)
+    }
+    // Pattern was already validated in parseNamePattern, so we can ignore errors here.
+    matched, _ := path.Match(m.Glob, name)
+    return matched
+}
+
+// A namePattern matches a project and, optionally, the assets within that project.
+type namePattern struct {
+    Project nameMatcher
+    Asset *nameMatcher // if nil, the pattern matches all assets in the project.
+}
+
+func (p namePattern) matchesProject(project string) bool {
+    return p.Project.matches(project)
+}
+
+func (p namePattern) matchesAsset(project, asset string) bool {
+    if !p.Project.matches(project) {
+        return false
+    }
+    return p.Asset == nil || p.Asset.matches(asset)
+}
+
+// Parses a pattern of the form "PROJECT" or "PROJECT/ASSET", where each component is a glob pattern.
+// If the pattern is prefixed with "regex:", each component is instead treated as a regular expression;
+// note that these are not anchored, so users should add "^" and "$" as necessary.
+func parseNamePattern(spec string) (namePattern, error) {
+    output := namePattern{}
+
+    use_regex := strings.HasPrefix(spec, "regex:")
+    if use_regex {
+        spec = strings.TrimPrefix(spec, "regex:")
+    }
+
+    create := func(component string) (nameMatcher, error) {
+        if component == "" {
+            return nameMatcher{}, fmt.Errorf("empty component in name pattern %q", spec)
+        }
+        if use_regex {
+            compiled, err := regexp.Compile(component)
+            if err != nil {
+                return nameMatcher{}, fmt.Errorf("invalid regular expression in name pattern %q; %w", spec, err)
+            }
+            return nameMatcher{ Regexp: compiled }, nil
+        }
+        if _, err := path.Match(component, ""); err != nil {
+            return nameMatcher{}, fmt.Errorf("invalid glob in name pattern %q; %w", spec, err)
+        }
+        return nameMatcher{ Glob: component }, nil
+    }
+
+    project, asset, has_asset := strings.Cut(spec, "/")
+    var err error
+    output.Project, err = create(project)
+    if err != nil {
+        return output, err
+    }
+
+    if has_asset {
+        matcher, err := create(asset)
+        if err != nil {
+            return output, err
+        }
+        output.Asset = &matcher
+    }
+
+    return output, nil
+}
+
+func parseNamePatterns(specs []string) ([]namePattern, error) {
+    output := []namePattern{}
+    for _, spec := range specs {
+        pattern, err := parseNamePattern(spec)
+        if err != nil {
+            return nil, err
+        }
+        output = append(output, pattern)
+    }
+    return output, nil
+}
+
+// A nameFilter decides whether a project or asset should be indexed.
+// An asset is indexed if it matches any of the 'Include' patterns (or if there are no 'Include' patterns),
+// and it does not match any of the 'Exclude' patterns.
+type nameFilter struct {
+    Include []namePattern
+    Exclude []namePattern
+}
+
+func (f nameFilter) allows(project, asset string) bool {
+    if len(f.Include) > 0 {
+        found := false
+        for _, pattern := range f.Include {
+            if pattern.matchesAsset(project, asset) {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return false
+        }
+    }
+
+    for _, pattern := range f.Exclude {
+        if pattern.matchesAsset(project, asset) {
+            return false
+        }
+    }
+
+    return true
+}
+
+// Whether all assets in a project are excluded by the filter.
+func (f nameFilter) excludesProject(project string) bool {
+    if len(f.Include) > 0 {
+        found := false
+        for _, pattern := range f.Include {
+            if pattern.matchesProject(project) {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return true
+        }
+    }
+
+    for _, pattern := range f.Exclude {
+        if pattern.Asset == nil && pattern.matchesProject(project) {
+            return true
+        }
+    }
+
+    return false
+}
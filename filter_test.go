@@ -0,0 +1,90 @@
+package main
+
+import (
+    "testing"
+    "strings"
+)
+
+func TestParseNamePattern(t *testing.T) {
+    pattern, err := parseNamePattern("test-*")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if pattern.Project.Glob != "test-*" || pattern.Asset != nil {
+        t.Errorf("unexpected project-only pattern; %v", pattern)
+    }
+    if !pattern.matchesAsset("test-foo", "bar") || pattern.matchesAsset("foo", "bar") {
+        t.Error("unexpected matches for a project-only pattern")
+    }
+
+    pattern, err = parseNamePattern("foo/tmp-*")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if pattern.Project.Glob != "foo" || pattern.Asset == nil || pattern.Asset.Glob != "tmp-*" {
+        t.Errorf("unexpected project/asset pattern; %v", pattern)
+    }
+    if !pattern.matchesAsset("foo", "tmp-bar") || pattern.matchesAsset("foo", "bar") || pattern.matchesAsset("whee", "tmp-bar") {
+        t.Error("unexpected matches for a project/asset pattern")
+    }
+
+    pattern, err = parseNamePattern("regex:^sandbox[0-9]+$/^v")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if pattern.Project.Regexp == nil || pattern.Asset == nil || pattern.Asset.Regexp == nil {
+        t.Errorf("expected a regex pattern; %v", pattern)
+    }
+    if !pattern.matchesAsset("sandbox123", "v1") || pattern.matchesAsset("sandbox", "v1") || pattern.matchesAsset("sandbox123", "a1") {
+        t.Error("unexpected matches for a regex pattern")
+    }
+
+    _, err = parseNamePattern("foo/")
+    if err == nil || !strings.Contains(err.Error(), "empty component") {
+        t.Error("expected an error for an empty asset component")
+    }
+
+    _, err = parseNamePattern("[")
+    if err == nil || !strings.Contains(err.Error(), "invalid glob") {
+        t.Error("expected an error for an invalid glob")
+    }
+
+    _, err = parseNamePattern("regex:(")
+    if err == nil || !strings.Contains(err.Error(), "invalid regular expression") {
+        t.Error("expected an error for an invalid regex")
+    }
+}
+
+func TestNameFilter(t *testing.T) {
+    filter := nameFilter{}
+    if !filter.allows("foo", "bar") || filter.excludesProject("foo") {
+        t.Error("empty filter should allow everything")
+    }
+
+    exclude, err := parseNamePatterns([]string{ "test-*", "foo/tmp-*" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    filter.Exclude = exclude
+    if filter.allows("test-foo", "bar") || !filter.excludesProject("test-foo") {
+        t.Error("excluded project should not be allowed")
+    }
+    if filter.allows("foo", "tmp-bar") || !filter.allows("foo", "bar") || filter.excludesProject("foo") {
+        t.Error("excluded asset should not be allowed, but other assets should be")
+    }
+
+    include, err := parseNamePatterns([]string{ "foo", "whee/stuff" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    filter.Include = include
+    if !filter.allows("foo", "bar") || filter.allows("foo", "tmp-bar") {
+        t.Error("included project should be allowed, except for the excluded assets")
+    }
+    if !filter.allows("whee", "stuff") || filter.allows("whee", "blah") || filter.excludesProject("whee") {
+        t.Error("included asset should be allowed, but not other assets in the same project")
+    }
+    if filter.allows("other", "bar") || !filter.excludesProject("other") {
+        t.Error("projects that are not included should be excluded")
+    }
+}
@@ -5,24 +5,125 @@ import (
     "path/filepath"
     "errors"
     "fmt"
+    "context"
+    "sync"
+    "strings"
+    "log/slog"
+    "github.com/ArtifactDB/sayoko/sewerrat"
 )
 
-func fullScan(rest_url string, registry string, names []string) error {
+// A registeredIndex contains all registered directories in the registry, to avoid querying SewerRat separately for each asset in a full scan.
+type registeredIndex struct {
+    // Registered paths relative to each asset directory, keyed by 'project/asset'.
+    Assets map[string][]string
+
+    // All registered paths within each project directory, keyed by project.
+    Projects map[string][]string
+}
+
+func fetchRegisteredIndex(ctx context.Context, client *sewerrat.Client, registry string) (*registeredIndex, error) {
+    registered, err := client.ListRegisteredDirectories(ctx, &sewerrat.ListOptions{ WithinPath: registry })
+    if err != nil {
+        return nil, fmt.Errorf("failed to list registered directories in the registry; %w", err)
+    }
+
+    output := &registeredIndex{
+        Assets: map[string][]string{},
+        Projects: map[string][]string{},
+    }
+    for _, reg := range registered {
+        rel, err := filepath.Rel(registry, reg.Path)
+        if err != nil || !filepath.IsLocal(rel) {
+            continue
+        }
+
+        components := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+        project := components[0]
+        output.Projects[project] = append(output.Projects[project], reg.Path)
+        if len(components) == 1 {
+            continue
+        }
+
+        // Mimicking ListRegisteredSubdirectories, where the asset directory itself is reported as '.'.
+        key := project + "/" + components[1]
+        version := "."
+        if len(components) == 3 {
+            version = filepath.FromSlash(components[2])
+        }
+        output.Assets[key] = append(output.Assets[key], version)
+    }
+
+    return output, nil
+}
+
+// Reconciles all projects and assets in the registry, using up to 'concurrency' workers to process the assets in parallel.
+func fullScan(ctx context.Context, client *sewerrat.Client, registry string, config *indexConfig, concurrency int) error {
     contents, err := os.ReadDir(registry) 
     if err != nil {
         return fmt.Errorf("failed to read the registry contents; %w", err)
     }
 
+    // Fetching all registered directories at once, which is much faster than querying SewerRat for each asset.
+    index, err := fetchRegisteredIndex(ctx, client, registry)
+    if err != nil {
+        return err
+    }
+
     all_errors := []error{}
+    var error_lock sync.Mutex
+    addError := func(err error) {
+        if err != nil {
+            error_lock.Lock()
+            defer error_lock.Unlock()
+            all_errors = append(all_errors, err)
+        }
+    }
+
+    // Each job (de)registers the versions of a single asset or the assets of an excluded project.
+    if concurrency < 1 {
+        concurrency = 1
+    }
+    jobs := make(chan func() error)
+    var wg sync.WaitGroup
+    for i := 0; i < concurrency; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for job := range jobs {
+                addError(job())
+            }
+        }()
+    }
+
     for _, proj := range contents {
         if !proj.IsDir() {
             continue
         }
+        if err := ctx.Err(); err != nil {
+            break
+        }
         project := proj.Name()
         project_dir := filepath.Join(registry, project)
+        if config.excludesProject(project) {
+            for _, reg := range index.Projects[project] {
+                if ctx.Err() != nil {
+                    break
+                }
+                jobs <- func() error {
+                    err := client.DeregisterDirectory(ctx, reg)
+                    if err != nil {
+                        slog.Error("failed to deregister an excluded project", "action", sewerrat.ActionDeregister, "project", project, "path", reg, "error", err)
+                    }
+                    return err
+                }
+            }
+            continue
+        }
+
         asses, err := os.ReadDir(project_dir)
         if err != nil {
-            all_errors = append(all_errors, fmt.Errorf("failed to list assets for project %q; %w", project, err))
+            slog.Error("failed to list assets", "project", project, "error", err)
+            addError(fmt.Errorf("failed to list assets for project %q; %w", project, err))
             continue
         }
 
@@ -30,17 +131,36 @@ func fullScan(rest_url string, registry string, names []string) error {
             if !ass.IsDir() {
                 continue
             }
+            if err := ctx.Err(); err != nil {
+                break
+            }
             asset := ass.Name()
             asset_dir := filepath.Join(project_dir, asset)
-            err := ignoreNonLatest(rest_url, asset_dir, names, false) // don't forcibly reregister as any file changes should get picked up by SewerRat's own periodic scans.
-            all_errors = append(all_errors, err)
+            registered_versions := index.Assets[project + "/" + asset]
+            jobs <- func() error {
+                err := ignoreNonLatestRaw(ctx, client, asset_dir, config.lookup(project, asset), false, registered_versions) // don't forcibly reregister as any file changes should get picked up by SewerRat's own periodic scans.
+                if err != nil {
+                    slog.Error("failed to reconcile asset", "project", project, "asset", asset, "error", err)
+                }
+                return err
+            }
         }
     }
 
+    close(jobs)
+    wg.Wait()
+
+    // If we were cancelled, any in-flight requests for the current assets were aborted, so we abandon the rest of the scan.
+    if err := ctx.Err(); err != nil {
+        all_errors = append(all_errors, fmt.Errorf("full scan was cancelled; %w", err))
+        return errors.Join(all_errors...)
+    }
+
     // Put this _after_ we check that we can list the contents of the registry,
     // to avoid premature deregistration upon sporadic unmounting of the registry's FS.
-    err = deregisterMissingSubdirectories(rest_url, registry)
+    err = client.DeregisterMissingSubdirectories(ctx, registry)
     if err != nil {
+        slog.Error("failed to deregister missing directories", "action", sewerrat.ActionDeregister, "error", err)
         all_errors = append(all_errors, err)
     }
 
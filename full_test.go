@@ -1,10 +1,19 @@
 package main
 
 import (
+    "context"
     "testing"
     "os"
     "path/filepath"
     "sort"
+    "errors"
+    "strings"
+    "net/url"
+    "net/http"
+    "net/http/httptest"
+    "net/http/httputil"
+    "sync/atomic"
+    "github.com/ArtifactDB/sayoko/sewerrat"
 )
 
 func TestFullScan(t *testing.T) {
@@ -41,17 +50,17 @@ func TestFullScan(t *testing.T) {
         t.Fatal(err)
     }
 
-    url := getSewerRatUrl()
-    names := []string{ "metadata.json" }
+    client := getSewerRatClient()
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
 
     // Initial run registers everything.
     {
-        err := fullScan(url, registry, names)
+        err := fullScan(context.Background(), client, registry, config, 1)
         if err != nil {
             t.Fatal(err)
         }
 
-        found, err := listRegisteredSubdirectories(url, registry)
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
         if err != nil {
             t.Fatal(err)
         }
@@ -69,12 +78,12 @@ func TestFullScan(t *testing.T) {
             t.Fatal(err)
         }
 
-        err = fullScan(url, registry, names)
+        err = fullScan(context.Background(), client, registry, config, 1)
         if err != nil {
             t.Fatal(err)
         }
 
-        found, err := listRegisteredSubdirectories(url, registry)
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
         if err != nil {
             t.Fatal(err)
         }
@@ -84,3 +93,303 @@ func TestFullScan(t *testing.T) {
         }
     }
 }
+
+func TestFullScanExcluded(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    for _, project := range []string{ "scratch-foo", "reference" } {
+        err = os.MkdirAll(filepath.Join(registry, project, "bar", "1"), 0755)
+        if err != nil {
+            t.Fatalf("failed to create a '%s/bar' project; %v", project, err)
+        }
+        err = os.WriteFile(filepath.Join(registry, project, "bar", "..latest"), []byte("{ \"version\": \"1\" }"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    client := getSewerRatClient()
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+
+    // Initial run registers everything.
+    {
+        err := fullScan(context.Background(), client, registry, config, 1)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 2 {
+            t.Errorf("unexpected results after a full scan; %v", found)
+        }
+    }
+
+    // Next run deregisters the excluded project.
+    {
+        config.Rules = append(config.Rules, policyRule{ Project: "scratch-*", Asset: "*", Policy: indexPolicy{ Exclude: true } })
+        err = fullScan(context.Background(), client, registry, config, 1)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 1 || found[0] != "reference/bar/1" {
+            t.Errorf("unexpected results after a full scan; %v", found)
+        }
+    }
+}
+
+func TestFullScanFiltered(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    for _, project := range []string{ "test-foo", "foo" } {
+        for _, asset := range []string{ "bar", "tmp-bar" } {
+            err = os.MkdirAll(filepath.Join(registry, project, asset, "1"), 0755)
+            if err != nil {
+                t.Fatalf("failed to create a '%s/%s' asset; %v", project, asset, err)
+            }
+            err = os.WriteFile(filepath.Join(registry, project, asset, "..latest"), []byte("{ \"version\": \"1\" }"), 0644)
+            if err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    client := getSewerRatClient()
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+
+    // Initial run registers everything.
+    {
+        err := fullScan(context.Background(), client, registry, config, 1)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 4 {
+            t.Errorf("unexpected results after a full scan; %v", found)
+        }
+    }
+
+    // Next run deregisters everything that no longer passes the filter.
+    {
+        exclude, err := parseNamePatterns([]string{ "test-*", "regex:^foo$/^tmp-" })
+        if err != nil {
+            t.Fatal(err)
+        }
+        config.Filter.Exclude = exclude
+
+        err = fullScan(context.Background(), client, registry, config, 1)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 1 || found[0] != "foo/bar/1" {
+            t.Errorf("unexpected results after a full scan; %v", found)
+        }
+    }
+}
+
+func TestFullScanCancelled(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    client := getSewerRatClient()
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+
+    missing := filepath.Join(registry, "foo", "bar", "1")
+    err = os.MkdirAll(missing, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = client.RegisterDirectory(context.Background(), missing, config.Default.Names)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.RemoveAll(filepath.Join(registry, "foo"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    err = fullScan(ctx, client, registry, config, 1)
+    if err == nil || !errors.Is(err, context.Canceled) {
+        t.Errorf("expected a cancellation error; %v", err)
+    }
+
+    // Missing directories should not be deregistered after cancellation.
+    found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0] != "foo/bar/1" {
+        t.Errorf("unexpected results after a cancelled full scan; %v", found)
+    }
+}
+
+func TestFullScanConcurrent(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    expected := []string{}
+    for _, project := range []string{ "foo", "shibuya", "heanna" } {
+        for _, asset := range []string{ "bar", "kanon", "aria", "sumire", "chisato" } {
+            asset_dir := filepath.Join(registry, project, asset)
+            err := os.MkdirAll(filepath.Join(asset_dir, "1"), 0755)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = os.MkdirAll(filepath.Join(asset_dir, "2"), 0755)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = os.WriteFile(filepath.Join(asset_dir, "..latest"), []byte("{ \"version\": \"2\" }"), 0644)
+            if err != nil {
+                t.Fatal(err)
+            }
+            expected = append(expected, project + "/" + asset + "/2")
+        }
+    }
+    sort.Strings(expected)
+
+    // Adding an asset with a malformed '..latest' to check that errors are still reported.
+    err = os.MkdirAll(filepath.Join(registry, "liella", "broken", "1"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(registry, "liella", "broken", "..latest"), []byte("{ \"version\": "), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := getSewerRatClient()
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+    err = fullScan(context.Background(), client, registry, config, 4)
+    if err == nil || !strings.Contains(err.Error(), "broken") {
+        t.Errorf("expected an error for the broken asset; %v", err)
+    }
+
+    found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sort.Strings(found)
+    if len(found) != len(expected) {
+        t.Fatalf("unexpected results after a concurrent full scan; %v", found)
+    }
+    for i, f := range found {
+        if f != expected[i] {
+            t.Errorf("unexpected results after a concurrent full scan; %v", found)
+            break
+        }
+    }
+}
+
+func TestFullScanPrefetch(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    for _, asset := range []string{ "bar", "kanon", "aria", "sumire" } {
+        asset_dir := filepath.Join(registry, "foo", asset)
+        err := os.MkdirAll(filepath.Join(asset_dir, "1"), 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.WriteFile(filepath.Join(asset_dir, "..latest"), []byte("{ \"version\": \"1\" }"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // Counting the number of listing queries, excluding requests for subsequent pages.
+    target, err := url.Parse(getSewerRatUrl())
+    if err != nil {
+        t.Fatal(err)
+    }
+    proxy := httputil.NewSingleHostReverseProxy(target)
+    var queries atomic.Int32
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/registered" && !r.URL.Query().Has("start") {
+            queries.Add(1)
+        }
+        proxy.ServeHTTP(w, r)
+    }))
+    defer server.Close()
+
+    client := sewerrat.NewClient(server.URL)
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+
+    // Registering everything on the first run.
+    err = fullScan(context.Background(), client, registry, config, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if queries.Load() != 2 { // once for the prefetch, once for the missing directories.
+        t.Errorf("unexpected number of listing queries; %v", queries.Load())
+    }
+
+    found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 4 {
+        t.Errorf("unexpected results after a full scan; %v", found)
+    }
+
+    // Second run uses the prefetched state to deregister old versions.
+    for _, asset := range []string{ "bar", "kanon" } {
+        asset_dir := filepath.Join(registry, "foo", asset)
+        err := os.MkdirAll(filepath.Join(asset_dir, "2"), 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.WriteFile(filepath.Join(asset_dir, "..latest"), []byte("{ \"version\": \"2\" }"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    queries.Store(0)
+    err = fullScan(context.Background(), client, registry, config, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if queries.Load() != 2 {
+        t.Errorf("unexpected number of listing queries; %v", queries.Load())
+    }
+
+    found, err = client.ListRegisteredSubdirectories(context.Background(), registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sort.Strings(found)
+    if len(found) != 4 || found[0] != "foo/aria/1" || found[1] != "foo/bar/2" || found[2] != "foo/kanon/2" || found[3] != "foo/sumire/1" {
+        t.Errorf("unexpected results after a full scan; %v", found)
+    }
+}
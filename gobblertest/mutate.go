@@ -0,0 +1,101 @@
+package gobblertest
+
+import (
+    "os"
+    "fmt"
+    "path/filepath"
+    "encoding/json"
+)
+
+// Each mutation modifies the registry in the same manner as the corresponding Gobbler operation,
+// and then writes the matching log file, returning its name.
+
+// Adds a new version to an asset, creating the project and asset if they do not already exist.
+// If the version is not probational, it becomes the latest version of the asset.
+func (r *Registry) AddVersion(project, asset string, version Version) (string, error) {
+    err := r.writeVersion(project, asset, version)
+    if err != nil {
+        return "", err
+    }
+
+    is_latest := false
+    if !version.Probation && !version.Finish.IsZero() {
+        err := r.writeLatest(project, asset, version.Name)
+        if err != nil {
+            return "", err
+        }
+        is_latest = true
+    }
+
+    return r.WriteLog(map[string]interface{}{ "type": "add-version", "project": project, "asset": asset, "version": version.Name, "latest": is_latest })
+}
+
+// Deletes a version of an asset, updating the '..latest' file to the next most recent version.
+func (r *Registry) DeleteVersion(project, asset, version string) (string, error) {
+    previous := map[string]string{}
+    contents, err := os.ReadFile(filepath.Join(r.assetDir(project, asset), "..latest"))
+    if err == nil {
+        json.Unmarshal(contents, &previous)
+    }
+
+    version_dir := filepath.Join(r.assetDir(project, asset), version)
+    err = os.RemoveAll(version_dir)
+    if err != nil {
+        return "", fmt.Errorf("failed to remove %q; %w", version_dir, err)
+    }
+    _, err = r.updateLatest(project, asset)
+    if err != nil {
+        return "", err
+    }
+    return r.WriteLog(map[string]interface{}{ "type": "delete-version", "project": project, "asset": asset, "version": version, "latest": (previous["version"] == version) })
+}
+
+// Reindexes a version of an asset.
+// This does not modify the registry, only emitting the log.
+func (r *Registry) ReindexVersion(project, asset, version string) (string, error) {
+    return r.WriteLog(map[string]interface{}{ "type": "reindex-version", "project": project, "asset": asset, "version": version })
+}
+
+// Deletes an asset and all of its versions.
+func (r *Registry) DeleteAsset(project, asset string) (string, error) {
+    asset_dir := r.assetDir(project, asset)
+    err := os.RemoveAll(asset_dir)
+    if err != nil {
+        return "", fmt.Errorf("failed to remove %q; %w", asset_dir, err)
+    }
+    return r.WriteLog(map[string]interface{}{ "type": "delete-asset", "project": project, "asset": asset })
+}
+
+// Deletes a project and all of its assets.
+func (r *Registry) DeleteProject(project string) (string, error) {
+    project_dir := filepath.Join(r.Path, project)
+    err := os.RemoveAll(project_dir)
+    if err != nil {
+        return "", fmt.Errorf("failed to remove %q; %w", project_dir, err)
+    }
+    return r.WriteLog(map[string]interface{}{ "type": "delete-project", "project": project })
+}
+
+func (r *Registry) setProbation(project, asset, version string, probation bool) error {
+    version_dir := filepath.Join(r.assetDir(project, asset), version)
+    summary, err := readSummary(version_dir)
+    if err != nil {
+        return fmt.Errorf("failed to read the summary for %q; %w", version_dir, err)
+    }
+    summary.OnProbation = probation
+    return writeJson(filepath.Join(version_dir, "..summary"), summary)
+}
+
+// Approves a probational version, which becomes the latest version of the asset if it is the most recent.
+// Gobbler does not have a separate log type for approvals, so this is reported as an 'add-version' log.
+func (r *Registry) ApproveProbation(project, asset, version string) (string, error) {
+    err := r.setProbation(project, asset, version, false)
+    if err != nil {
+        return "", err
+    }
+    latest, err := r.updateLatest(project, asset)
+    if err != nil {
+        return "", err
+    }
+    return r.WriteLog(map[string]interface{}{ "type": "add-version", "project": project, "asset": asset, "version": version, "latest": (latest == version) })
+}
@@ -0,0 +1,303 @@
+// Package gobblertest builds synthetic Gobbler registries for testing and benchmarking.
+//
+// A registry is created from a declarative Spec, containing the projects, assets and versions along with their '..latest' and '..summary' files.
+// The Registry's methods then mimic the Gobbler's own operations (e.g., adding a version, deleting an asset),
+// modifying the directory contents and writing the matching log file into the '..logs' subdirectory.
+package gobblertest
+
+import (
+    "os"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "errors"
+    "path/filepath"
+    "encoding/json"
+)
+
+// A Version describes a version of an asset in the registry.
+type Version struct {
+    Name string
+
+    // Time at which the upload finished, used in the '..summary' file.
+    // If zero, the version is treated as an incomplete upload without a '..summary' file.
+    Finish time.Time
+
+    // Whether the version is probational.
+    Probation bool
+
+    // Contents of the files in the version directory, keyed by their paths relative to that directory.
+    // If nil, a single 'metadata.json' file is created containing the project, asset and version names.
+    Files map[string]string
+}
+
+// An Asset describes an asset in the registry.
+type Asset struct {
+    Name string
+    Versions []Version
+
+    // Version to be listed in the '..latest' file.
+    // If empty, this is set to the most recently finished non-probational version, if any.
+    Latest string
+}
+
+// A Project describes a project in the registry.
+type Project struct {
+    Name string
+    Assets []Asset
+}
+
+// A Spec describes the initial contents of a registry.
+type Spec struct {
+    Projects []Project
+}
+
+// A Registry is a synthetic Gobbler registry on the filesystem.
+// Its methods can be safely called from multiple goroutines.
+type Registry struct {
+    // Path to the registry directory.
+    Path string
+
+    // Timestamp to use in the name of the next log file.
+    // Each log advances this by one second so that the logs are named in the order of their creation.
+    Clock time.Time
+
+    lock sync.Mutex
+    counter int
+}
+
+// Creates a registry at 'dir' (which may or may not already exist) with the contents described by 'spec'.
+// The registry's clock is initialized to the current time.
+func Create(dir string, spec Spec) (*Registry, error) {
+    err := os.MkdirAll(filepath.Join(dir, "..logs"), 0755)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create the log directory in %q; %w", dir, err)
+    }
+
+    reg := &Registry{ Path: dir, Clock: time.Now().UTC().Truncate(time.Second) }
+    for _, proj := range spec.Projects {
+        for _, ass := range proj.Assets {
+            for _, ver := range ass.Versions {
+                err := reg.writeVersion(proj.Name, ass.Name, ver)
+                if err != nil {
+                    return nil, err
+                }
+            }
+
+            latest := ass.Latest
+            if latest == "" {
+                latest, err = reg.chooseLatest(proj.Name, ass.Name)
+                if err != nil {
+                    return nil, err
+                }
+            }
+            err := reg.writeLatest(proj.Name, ass.Name, latest)
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    return reg, nil
+}
+
+// Generates a spec with 'projects' projects, each with 'assets' assets, each with 'versions' versions.
+// Projects, assets and versions are named 'project-N', 'asset-N' and 'N' respectively (where versions are 1-based),
+// and later versions have later finish times, so the last version of each asset is the latest.
+func GenerateSpec(projects int, assets int, versions int) Spec {
+    base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+    spec := Spec{ Projects: make([]Project, projects) }
+    for p := range spec.Projects {
+        proj := &(spec.Projects[p])
+        proj.Name = fmt.Sprintf("project-%d", p)
+        proj.Assets = make([]Asset, assets)
+        for a := range proj.Assets {
+            ass := &(proj.Assets[a])
+            ass.Name = fmt.Sprintf("asset-%d", a)
+            ass.Versions = make([]Version, versions)
+            for v := range ass.Versions {
+                ass.Versions[v] = Version{ Name: fmt.Sprint(v + 1), Finish: base.Add(time.Duration(v) * time.Hour) }
+            }
+        }
+    }
+    return spec
+}
+
+func (r *Registry) assetDir(project, asset string) string {
+    return filepath.Join(r.Path, project, asset)
+}
+
+type summaryFile struct {
+    UploadUserId string `json:"upload_user_id"`
+    UploadStart string `json:"upload_start"`
+    UploadFinish string `json:"upload_finish"`
+    OnProbation bool `json:"on_probation,omitempty"`
+}
+
+func writeJson(path string, payload interface{}) error {
+    contents, err := json.Marshal(payload)
+    if err != nil {
+        return fmt.Errorf("failed to serialize the contents of %q; %w", path, err)
+    }
+    err = os.WriteFile(path, contents, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to write %q; %w", path, err)
+    }
+    return nil
+}
+
+func (r *Registry) writeVersion(project, asset string, version Version) error {
+    version_dir := filepath.Join(r.assetDir(project, asset), version.Name)
+    err := os.MkdirAll(version_dir, 0755)
+    if err != nil {
+        return fmt.Errorf("failed to create %q; %w", version_dir, err)
+    }
+
+    files := version.Files
+    if files == nil {
+        contents, err := json.Marshal(map[string]string{ "project": project, "asset": asset, "version": version.Name })
+        if err != nil {
+            return err
+        }
+        files = map[string]string{ "metadata.json": string(contents) }
+    }
+    for rel, contents := range files {
+        path := filepath.Join(version_dir, rel)
+        err := os.MkdirAll(filepath.Dir(path), 0755)
+        if err != nil {
+            return fmt.Errorf("failed to create the parent directory of %q; %w", path, err)
+        }
+        err = os.WriteFile(path, []byte(contents), 0644)
+        if err != nil {
+            return fmt.Errorf("failed to write %q; %w", path, err)
+        }
+    }
+
+    if version.Finish.IsZero() {
+        return nil
+    }
+    return writeJson(filepath.Join(version_dir, "..summary"), summaryFile{
+        UploadUserId: "gobbler",
+        UploadStart: version.Finish.Add(-time.Minute).Format(time.RFC3339),
+        UploadFinish: version.Finish.Format(time.RFC3339),
+        OnProbation: version.Probation,
+    })
+}
+
+func readSummary(version_dir string) (summaryFile, error) {
+    output := summaryFile{}
+    contents, err := os.ReadFile(filepath.Join(version_dir, "..summary"))
+    if err != nil {
+        return output, err
+    }
+    err = json.Unmarshal(contents, &output)
+    if err != nil {
+        return output, fmt.Errorf("failed to parse the summary in %q; %w", version_dir, err)
+    }
+    return output, nil
+}
+
+// Chooses the most recently finished non-probational version of an asset, or an empty string if there are none.
+func (r *Registry) chooseLatest(project, asset string) (string, error) {
+    asset_dir := r.assetDir(project, asset)
+    contents, err := os.ReadDir(asset_dir)
+    if err != nil {
+        return "", fmt.Errorf("failed to list versions in %q; %w", asset_dir, err)
+    }
+
+    latest := ""
+    latest_finish := time.Time{}
+    for _, entry := range contents {
+        if !entry.IsDir() {
+            continue
+        }
+        summary, err := readSummary(filepath.Join(asset_dir, entry.Name()))
+        if err != nil {
+            if errors.Is(err, os.ErrNotExist) {
+                continue
+            }
+            return "", err
+        }
+        if summary.OnProbation {
+            continue
+        }
+        finish, err := time.Parse(time.RFC3339, summary.UploadFinish)
+        if err != nil {
+            return "", fmt.Errorf("failed to parse the finish time for %q; %w", filepath.Join(asset_dir, entry.Name()), err)
+        }
+        if latest == "" || finish.After(latest_finish) || (finish.Equal(latest_finish) && entry.Name() > latest) {
+            latest = entry.Name()
+            latest_finish = finish
+        }
+    }
+    return latest, nil
+}
+
+// Writes the '..latest' file for an asset, or removes it if 'latest' is empty.
+func (r *Registry) writeLatest(project, asset string, latest string) error {
+    path := filepath.Join(r.assetDir(project, asset), "..latest")
+    if latest == "" {
+        err := os.Remove(path)
+        if err != nil && !errors.Is(err, os.ErrNotExist) {
+            return fmt.Errorf("failed to remove %q; %w", path, err)
+        }
+        return nil
+    }
+    return writeJson(path, map[string]string{ "version": latest })
+}
+
+func (r *Registry) updateLatest(project, asset string) (string, error) {
+    latest, err := r.chooseLatest(project, asset)
+    if err != nil {
+        return "", err
+    }
+    return latest, r.writeLatest(project, asset, latest)
+}
+
+// Writes a log file with the specified contents to the '..logs' directory, returning the name of the log file.
+// The name is of the form '<RFC3339>_<ID>', using the registry's clock for the timestamp.
+func (r *Registry) WriteLog(payload map[string]interface{}) (string, error) {
+    r.lock.Lock()
+    stamp := r.Clock
+    r.Clock = r.Clock.Add(time.Second)
+    r.counter += 1
+    name := fmt.Sprintf("%s_%06d", stamp.Format(time.RFC3339), r.counter)
+    r.lock.Unlock()
+
+    err := writeJson(filepath.Join(r.Path, "..logs", name), payload)
+    if err != nil {
+        return "", err
+    }
+    return name, nil
+}
+
+// Names of all log files in the registry, sorted by their timestamps.
+func (r *Registry) Logs() ([]string, error) {
+    logdir := filepath.Join(r.Path, "..logs")
+    handle, err := os.Open(logdir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open %q; %w", logdir, err)
+    }
+    defer handle.Close()
+    names, err := handle.Readdirnames(0)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list %q; %w", logdir, err)
+    }
+    sort.Strings(names) // RFC3339 timestamps in UTC are lexicographically ordered.
+    return names, nil
+}
+
+// Removes all log files from the registry.
+func (r *Registry) ClearLogs() error {
+    logdir := filepath.Join(r.Path, "..logs")
+    err := os.RemoveAll(logdir)
+    if err != nil {
+        return fmt.Errorf("failed to remove %q; %w", logdir, err)
+    }
+    err = os.Mkdir(logdir, 0755)
+    if err != nil {
+        return fmt.Errorf("failed to recreate %q; %w", logdir, err)
+    }
+    return nil
+}
@@ -0,0 +1,195 @@
+package gobblertest
+
+import (
+    "os"
+    "time"
+    "testing"
+    "path/filepath"
+    "encoding/json"
+)
+
+func readJsonFile(t *testing.T, path string) map[string]interface{} {
+    contents, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    output := map[string]interface{}{}
+    err = json.Unmarshal(contents, &output)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return output
+}
+
+func TestCreate(t *testing.T) {
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    finish := time.Date(2022, 2, 22, 2, 22, 22, 0, time.UTC)
+    reg, err := Create(dir, Spec{
+        Projects: []Project{
+            Project{
+                Name: "liella",
+                Assets: []Asset{
+                    Asset{
+                        Name: "kanon",
+                        Versions: []Version{
+                            Version{ Name: "1", Finish: finish },
+                            Version{ Name: "2", Finish: finish.Add(time.Hour) },
+                            Version{ Name: "3", Finish: finish.Add(2 * time.Hour), Probation: true },
+                            Version{ Name: "4" },
+                        },
+                    },
+                    Asset{
+                        Name: "keke",
+                        Versions: []Version{
+                            Version{ Name: "1", Finish: finish, Files: map[string]string{ "foo/bar.json": "{}" } },
+                        },
+                        Latest: "1",
+                    },
+                },
+            },
+        },
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if latest := readJsonFile(t, filepath.Join(dir, "liella", "kanon", "..latest")); latest["version"] != "2" {
+        t.Errorf("expected the newest non-probational version to be the latest; %v", latest)
+    }
+    if summary := readJsonFile(t, filepath.Join(dir, "liella", "kanon", "3", "..summary")); summary["on_probation"] != true {
+        t.Errorf("expected the probational version to be marked in its summary; %v", summary)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "liella", "kanon", "4", "..summary")); err == nil {
+        t.Error("expected no summary for an incomplete version")
+    }
+    if _, err := os.Stat(filepath.Join(dir, "liella", "kanon", "1", "metadata.json")); err != nil {
+        t.Errorf("expected a default metadata file; %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "liella", "keke", "1", "foo", "bar.json")); err != nil {
+        t.Errorf("expected the specified files to be created; %v", err)
+    }
+
+    logs, err := reg.Logs()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(logs) != 0 {
+        t.Errorf("expected no logs upon creation; %v", logs)
+    }
+}
+
+func TestGenerateSpec(t *testing.T) {
+    spec := GenerateSpec(2, 3, 4)
+    if len(spec.Projects) != 2 || len(spec.Projects[1].Assets) != 3 || len(spec.Projects[1].Assets[2].Versions) != 4 {
+        t.Fatalf("unexpected dimensions of the generated spec; %v", spec)
+    }
+
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = Create(dir, spec)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest := readJsonFile(t, filepath.Join(dir, "project-1", "asset-2", "..latest")); latest["version"] != "4" {
+        t.Errorf("expected the last version to be the latest; %v", latest)
+    }
+}
+
+func TestMutations(t *testing.T) {
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    reg, err := Create(dir, GenerateSpec(1, 2, 2))
+    if err != nil {
+        t.Fatal(err)
+    }
+    reg.Clock = time.Date(2022, 2, 22, 2, 22, 22, 0, time.UTC)
+    finish := time.Date(2023, 3, 23, 3, 33, 33, 0, time.UTC)
+
+    name, err := reg.AddVersion("project-0", "asset-0", Version{ Name: "3", Finish: finish })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if name != "2022-02-22T02:22:22Z_000001" {
+        t.Errorf("unexpected log name; %q", name)
+    }
+    if entry := readJsonFile(t, filepath.Join(dir, "..logs", name)); entry["type"] != "add-version" || entry["version"] != "3" || entry["latest"] != true {
+        t.Errorf("unexpected log contents; %v", entry)
+    }
+    if latest := readJsonFile(t, filepath.Join(dir, "project-0", "asset-0", "..latest")); latest["version"] != "3" {
+        t.Errorf("expected the added version to be the latest; %v", latest)
+    }
+
+    // Probational versions do not affect the latest version until they are approved.
+    _, err = reg.AddVersion("project-0", "asset-0", Version{ Name: "4", Finish: finish.Add(time.Hour), Probation: true })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest := readJsonFile(t, filepath.Join(dir, "project-0", "asset-0", "..latest")); latest["version"] != "3" {
+        t.Errorf("expected a probational version to not be the latest; %v", latest)
+    }
+    name, err = reg.ApproveProbation("project-0", "asset-0", "4")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if entry := readJsonFile(t, filepath.Join(dir, "..logs", name)); entry["type"] != "add-version" || entry["version"] != "4" || entry["latest"] != true {
+        t.Errorf("unexpected log contents for an approval; %v", entry)
+    }
+    if latest := readJsonFile(t, filepath.Join(dir, "project-0", "asset-0", "..latest")); latest["version"] != "4" {
+        t.Errorf("expected an approved version to be the latest; %v", latest)
+    }
+
+    _, err = reg.DeleteVersion("project-0", "asset-0", "4")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest := readJsonFile(t, filepath.Join(dir, "project-0", "asset-0", "..latest")); latest["version"] != "3" {
+        t.Errorf("expected the latest version to be updated after deletion; %v", latest)
+    }
+
+    name, err = reg.DeleteAsset("project-0", "asset-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if entry := readJsonFile(t, filepath.Join(dir, "..logs", name)); entry["type"] != "delete-asset" || entry["asset"] != "asset-1" {
+        t.Errorf("unexpected log contents for an asset deletion; %v", entry)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "project-0", "asset-1")); err == nil {
+        t.Error("expected the asset to be deleted")
+    }
+
+    _, err = reg.DeleteProject("project-0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "project-0")); err == nil {
+        t.Error("expected the project to be deleted")
+    }
+
+    logs, err := reg.Logs()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(logs) != 6 || logs[0] != "2022-02-22T02:22:22Z_000001" || logs[5] != "2022-02-22T02:22:27Z_000006" {
+        t.Errorf("unexpected log names; %v", logs)
+    }
+
+    err = reg.ClearLogs()
+    if err != nil {
+        t.Fatal(err)
+    }
+    logs, err = reg.Logs()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(logs) != 0 {
+        t.Errorf("expected no logs after clearing; %v", logs)
+    }
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "time"
+    "errors"
+    "context"
+    "net/http"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+const readinessTimeout = 10 * time.Second
+
+// Whether sayoko is ready to do its job, i.e., the registry can be listed and SewerRat is reachable.
+func checkReadiness(ctx context.Context, client *sewerrat.Client, registry string) error {
+    // This mirrors the check in fullScan() to avoid deregistration upon sporadic unmounting of the registry's FS.
+    handle, err := os.Open(registry)
+    if err != nil {
+        return fmt.Errorf("failed to open the registry; %w", err)
+    }
+    defer handle.Close()
+    _, err = handle.Readdirnames(1)
+    if err != nil && !errors.Is(err, io.EOF) {
+        return fmt.Errorf("failed to list the registry contents; %w", err)
+    }
+
+    return client.Ping(ctx)
+}
+
+// Whether sayoko is still alive, i.e., no operation has been running for longer than 'deadline' at 'now'.
+// If 'deadline' is not positive, operations are never considered to be stuck.
+func checkLiveness(status daemonStatus, deadline time.Duration, now time.Time) error {
+    if deadline <= 0 {
+        return nil
+    }
+    if status.Logs.Running && now.Sub(*(status.Logs.LastStarted)) > deadline {
+        return fmt.Errorf("log check has been running since %s", status.Logs.LastStarted.Format(time.RFC3339))
+    }
+    if status.Full.Running && now.Sub(*(status.Full.LastStarted)) > deadline {
+        return fmt.Errorf("full scan has been running since %s", status.Full.LastStarted.Format(time.RFC3339))
+    }
+    return nil
+}
+
+func writeProbeResponse(w http.ResponseWriter, err error) {
+    w.Header().Set("Content-Type", "text/plain")
+    if err != nil {
+        w.WriteHeader(http.StatusServiceUnavailable)
+        fmt.Fprintln(w, err.Error())
+        return
+    }
+    fmt.Fprintln(w, "ok")
+}
+
+// Adds the '/healthz' (liveness) and '/readyz' (readiness) probes for the daemon 'd' to 'mux'.
+func addHealthHandlers(mux *http.ServeMux, d *daemon, deadline time.Duration) {
+    mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+        writeProbeResponse(w, checkLiveness(d.getStatus(), deadline, time.Now()))
+    })
+
+    mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+        ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+        defer cancel()
+        writeProbeResponse(w, checkReadiness(ctx, d.Client, d.Registry))
+    })
+}
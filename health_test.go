@@ -0,0 +1,120 @@
+package main
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+func TestCheckReadiness(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = checkReadiness(context.Background(), getSewerRatClient(), registry)
+    if err != nil {
+        t.Error(err)
+    }
+
+    err = checkReadiness(context.Background(), getSewerRatClient(), filepath.Join(registry, "missing"))
+    if err == nil || !strings.Contains(err.Error(), "registry") {
+        t.Errorf("expected a failure for a missing registry; %v", err)
+    }
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    server.Close()
+    err = checkReadiness(context.Background(), sewerrat.NewClient(server.URL), registry)
+    if err == nil || !strings.Contains(err.Error(), "SewerRat") {
+        t.Errorf("expected a failure for an unreachable SewerRat; %v", err)
+    }
+}
+
+func TestCheckLiveness(t *testing.T) {
+    now := time.Now()
+    started := now.Add(-time.Hour)
+    status := daemonStatus{}
+    status.Full.Running = true
+    status.Full.LastStarted = &started
+
+    if err := checkLiveness(status, 2 * time.Hour, now); err != nil {
+        t.Errorf("expected a running scan to be alive before the deadline; %v", err)
+    }
+    if err := checkLiveness(status, 30 * time.Minute, now); err == nil || !strings.Contains(err.Error(), "full scan") {
+        t.Errorf("expected a running scan to be stuck after the deadline; %v", err)
+    }
+    if err := checkLiveness(status, 0, now); err != nil {
+        t.Errorf("expected no failure without a deadline; %v", err)
+    }
+
+    status.Full.Running = false
+    status.Logs.Running = true
+    status.Logs.LastStarted = &started
+    if err := checkLiveness(status, 30 * time.Minute, now); err == nil || !strings.Contains(err.Error(), "log check") {
+        t.Errorf("expected a running log check to be stuck after the deadline; %v", err)
+    }
+
+    status.Logs.Running = false
+    if err := checkLiveness(status, 30 * time.Minute, now); err != nil {
+        t.Errorf("expected no failure without any running operations; %v", err)
+    }
+}
+
+func TestHealthHandlers(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    d := &daemon{ Client: getSewerRatClient(), Registry: registry }
+    mux := http.NewServeMux()
+    addHealthHandlers(mux, d, time.Minute)
+    server := httptest.NewServer(mux)
+    defer server.Close()
+
+    probe := func(endpoint string) (int, string) {
+        resp, err := http.Get(server.URL + endpoint)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer resp.Body.Close()
+        body, err := io.ReadAll(resp.Body)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return resp.StatusCode, string(body)
+    }
+
+    if code, body := probe("/healthz"); code != http.StatusOK || body != "ok\n" {
+        t.Errorf("unexpected liveness response; %d %s", code, body)
+    }
+    if code, body := probe("/readyz"); code != http.StatusOK {
+        t.Errorf("unexpected readiness response; %d %s", code, body)
+    }
+
+    // Simulating a stuck scan.
+    started := time.Now().Add(-time.Hour)
+    d.status_lock.Lock()
+    d.status.Full.Running = true
+    d.status.Full.LastStarted = &started
+    d.status_lock.Unlock()
+    if code, _ := probe("/healthz"); code != http.StatusServiceUnavailable {
+        t.Errorf("expected a liveness failure for a stuck scan; %d", code)
+    }
+
+    // Simulating an unmounted registry.
+    err = os.RemoveAll(registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if code, _ := probe("/readyz"); code != http.StatusServiceUnavailable {
+        t.Errorf("expected a readiness failure for a missing registry; %d", code)
+    }
+}
@@ -2,10 +2,14 @@ package main
 
 import (
     "os"
+    "io/fs"
     "path/filepath"
     "errors"
     "encoding/json"
     "fmt"
+    "context"
+    "log/slog"
+    "github.com/ArtifactDB/sayoko/sewerrat"
 )
 
 type latestInfo struct {
@@ -33,39 +37,173 @@ func readLatestFile(lat_path string) (latestInfo, error) {
     return output, nil
 }
 
-func ignoreNonLatest(rest_url, asset_dir string, names []string, force bool) error {
-    lat_path := filepath.Join(asset_dir, "..latest")
-    payload, err := readLatestFile(lat_path)
+type summaryInfo struct {
+    UploadFinish string `json:"upload_finish"`
+    OnProbation bool `json:"on_probation"`
+}
+
+func readSummaryFile(sum_path string) (summaryInfo, error) {
+    output := summaryInfo{}
+
+    handle, err := os.Open(sum_path)
+    if err != nil {
+        return output, fmt.Errorf("failed to open %q; %w", sum_path, err)
+    }
+    defer handle.Close()
+
+    dec := json.NewDecoder(handle)
+    err = dec.Decode(&output)
+    if err != nil {
+        return output, fmt.Errorf("failed to parse %q; %w", sum_path, err)
+    }
+
+    return output, nil
+}
+
+// Whether the version at 'version_dir' is probational, according to its '..summary' file.
+// Versions without a '..summary' file are assumed to be non-probational.
+func isProbational(version_dir string) (bool, error) {
+    summary, err := readSummaryFile(filepath.Join(version_dir, "..summary"))
     if err != nil {
-        return err
+        if errors.Is(err, os.ErrNotExist) {
+            return false, nil
+        }
+        return false, err
+    }
+    return summary.OnProbation, nil
+}
+
+// Chooses the latest version of the asset at 'asset_dir', given the version listed in its '..latest' file.
+// If that version is probational, this falls back to the most recently uploaded non-probational version, or an empty string if there are none.
+func chooseLatestVersion(asset_dir string, latest string) (string, error) {
+    if latest == "" {
+        return "", nil
+    }
+    probational, err := isProbational(filepath.Join(asset_dir, latest))
+    if err != nil || !probational {
+        return latest, err
     }
 
-    registered_versions, err := listRegisteredSubdirectories(rest_url, asset_dir)
+    completed, err := listCompletedVersions(asset_dir)
+    if err != nil {
+        return "", err
+    }
+    fallback := ""
+    if len(completed) > 0 {
+        fallback = completed[0]
+    }
+    slog.Info("latest version is probational", "project", filepath.Base(filepath.Dir(asset_dir)), "asset", filepath.Base(asset_dir), "version", latest, "fallback", fallback)
+    return fallback, nil
+}
+
+// Whether the version at 'version_dir' contains any file (in any subdirectory) with one of the metadata file 'names'.
+func hasMetadataFiles(version_dir string, names []string) (bool, error) {
+    wanted := map[string]bool{}
+    for _, n := range names {
+        wanted[n] = true
+    }
+
+    found := false
+    err := filepath.WalkDir(version_dir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if !d.IsDir() && wanted[d.Name()] {
+            found = true
+            return fs.SkipAll
+        }
+        return nil
+    })
+    if err != nil {
+        return false, fmt.Errorf("failed to search for metadata files in %q; %w", version_dir, err)
+    }
+    return found, nil
+}
+
+func ignoreNonLatest(ctx context.Context, client *sewerrat.Client, asset_dir string, policy indexPolicy, force bool) error {
+    registered_versions, err := client.ListRegisteredSubdirectories(ctx, asset_dir)
     if err != nil {
         return fmt.Errorf("failed to list registered versions of %q; %w", asset_dir, err)
     }
+    return ignoreNonLatestRaw(ctx, client, asset_dir, policy, force, registered_versions)
+}
+
+// Same as ignoreNonLatest, but with the currently registered versions (i.e., subdirectories of 'asset_dir') already supplied by the caller.
+func ignoreNonLatestRaw(ctx context.Context, client *sewerrat.Client, asset_dir string, policy indexPolicy, force bool, registered_versions []string) error {
+    retained_versions := []string{}
+    if !policy.Exclude {
+        lat_path := filepath.Join(asset_dir, "..latest")
+        payload, err := readLatestFile(lat_path)
+        if err != nil {
+            return err
+        }
+
+        latest, err := chooseLatestVersion(asset_dir, payload.Version)
+        if err != nil {
+            return fmt.Errorf("failed to choose the latest version for %q; %w", asset_dir, err)
+        }
+
+        candidates, err := policy.Retention.retainedVersions(asset_dir, latest)
+        if err != nil {
+            return fmt.Errorf("failed to choose versions to retain for %q; %w", asset_dir, err)
+        }
+
+        // Some policies (e.g., allow-lists) do not consider probation, so we need to filter them here.
+        for _, ver := range candidates {
+            probational, err := isProbational(filepath.Join(asset_dir, ver))
+            if err != nil {
+                return fmt.Errorf("failed to check probation for version %q of %q; %w", ver, asset_dir, err)
+            }
+            if probational {
+                slog.Info("skipping probational version", "project", filepath.Base(filepath.Dir(asset_dir)), "asset", filepath.Base(asset_dir), "version", ver)
+                continue
+            }
+            retained_versions = append(retained_versions, ver)
+        }
+    }
+    retained := map[string]bool{}
+    for _, ver := range retained_versions {
+        retained[ver] = true
+    }
 
     all_errors := []error{}
-    latest_registered := false
+    already_registered := map[string]bool{}
     for _, ver := range registered_versions {
-        if ver == payload.Version {
-            latest_registered = true
+        if retained[ver] {
+            already_registered[ver] = true
             continue
         }
         version_dir := filepath.Join(asset_dir, ver)
-        regerr := deregisterDirectory(rest_url, version_dir)
+        regerr := client.DeregisterDirectory(ctx, version_dir)
+        logVersionAction(client, sewerrat.ActionDeregister, version_dir, regerr)
         if regerr != nil {
             all_errors = append(all_errors, regerr)
         }
     }
 
-    if !latest_registered || force {
-        if payload.Version != "" {
-            version_dir := filepath.Join(asset_dir, payload.Version)
-            regerr := registerDirectory(rest_url, version_dir, names)
-            if regerr != nil {
-                all_errors = append(all_errors, regerr)
+    for _, ver := range retained_versions {
+        version_dir := filepath.Join(asset_dir, ver)
+        var regerr error
+        if !already_registered[ver] {
+            if policy.CheckNames {
+                found, err := hasMetadataFiles(version_dir, policy.Names)
+                if err != nil {
+                    all_errors = append(all_errors, err)
+                    continue
+                }
+                if !found {
+                    slog.Warn("skipping registration of a version without metadata files", "project", filepath.Base(filepath.Dir(asset_dir)), "asset", filepath.Base(asset_dir), "version", ver)
+                    continue
+                }
             }
+            regerr = client.RegisterDirectory(ctx, version_dir, policy.Names)
+            logVersionAction(client, sewerrat.ActionRegister, version_dir, regerr)
+        } else if force {
+            regerr = client.ReindexDirectory(ctx, version_dir, policy.Names)
+            logVersionAction(client, sewerrat.ActionReindex, version_dir, regerr)
+        }
+        if regerr != nil {
+            all_errors = append(all_errors, regerr)
         }
     }
 
@@ -1,10 +1,12 @@
 package main
 
 import (
+    "context"
     "os"
     "path/filepath"
     "testing"
     "strings"
+    "sort"
 )
 
 func TestReadLatestFile(t *testing.T) {
@@ -71,16 +73,17 @@ func TestIgnoreNonLatest(t *testing.T) {
     }
 
     names := []string{ "metadata.json" }
-    url := getSewerRatUrl()
+    policy := indexPolicy{ Names: names, Retention: latestOnlyRetention{} }
+    client := getSewerRatClient()
 
     // Simple initial run.
     {
-        err := ignoreNonLatest(url, asset_dir, names, false)
+        err := ignoreNonLatest(context.Background(), client, asset_dir, policy, false)
         if err != nil {
             t.Fatal(err)
         }
 
-        found, err := listRegisteredSubdirectories(url, asset_dir)
+        found, err := client.ListRegisteredSubdirectories(context.Background(), asset_dir)
         if err != nil {
             t.Fatal(err)
         }
@@ -97,12 +100,12 @@ func TestIgnoreNonLatest(t *testing.T) {
             t.Fatalf("failed to update the ..latest file; %v", err)
         }
 
-        err = ignoreNonLatest(url, asset_dir, names, false)
+        err = ignoreNonLatest(context.Background(), client, asset_dir, policy, false)
         if err != nil {
             t.Fatal(err)
         }
 
-        found, err := listRegisteredSubdirectories(url, asset_dir)
+        found, err := client.ListRegisteredSubdirectories(context.Background(), asset_dir)
         if err != nil {
             t.Fatal(err)
         }
@@ -119,12 +122,12 @@ func TestIgnoreNonLatest(t *testing.T) {
             t.Fatalf("failed to remove the ..latest file; %v", err)
         }
 
-        err := ignoreNonLatest(url, asset_dir, names, false)
+        err := ignoreNonLatest(context.Background(), client, asset_dir, policy, false)
         if err != nil {
             t.Fatal(err)
         }
 
-        found, err := listRegisteredSubdirectories(url, asset_dir)
+        found, err := client.ListRegisteredSubdirectories(context.Background(), asset_dir)
         if err != nil {
             t.Fatal(err)
         }
@@ -141,11 +144,11 @@ func TestIgnoreNonLatest(t *testing.T) {
             t.Fatalf("failed to write to the ..latest file; %v", err)
         }
 
-        err = ignoreNonLatest(url, asset_dir, names, false)
+        err = ignoreNonLatest(context.Background(), client, asset_dir, policy, false)
         if err != nil {
             t.Fatal(err)
         }
-        found, err := listRegisteredSubdirectories(url, asset_dir)
+        found, err := client.ListRegisteredSubdirectories(context.Background(), asset_dir)
         if err != nil {
             t.Fatal(err)
         }
@@ -160,11 +163,11 @@ func TestIgnoreNonLatest(t *testing.T) {
             t.Fatal(err)
         }
 
-        err = ignoreNonLatest(url, asset_dir, names, false)
+        err = ignoreNonLatest(context.Background(), client, asset_dir, policy, false)
         if err != nil {
             t.Fatal(err)
         }
-        found, err = listRegisteredSubdirectories(url, asset_dir)
+        found, err = client.ListRegisteredSubdirectories(context.Background(), asset_dir)
         if err != nil {
             t.Fatal(err)
         }
@@ -173,9 +176,263 @@ func TestIgnoreNonLatest(t *testing.T) {
         }
 
         // But if we do force it, we should see an error because the directory doesn't exist.
-        err = ignoreNonLatest(url, asset_dir, names, true)
+        err = ignoreNonLatest(context.Background(), client, asset_dir, policy, true)
         if err == nil || !strings.Contains(err.Error(), "does not exist") {
             t.Error("expected an error from forced reregistration")
         }
     }
 }
+
+func TestIgnoreNonLatestRetention(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    asset_dir := filepath.Join(registry, "liella", "sumire")
+    err = mockVersionsWithSummaries(asset_dir, map[string]string{
+        "1": "2021-01-21T02:22:22Z",
+        "2": "2022-02-22T02:22:22Z",
+        "3": "2023-03-23T03:33:33Z",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    lat_path := filepath.Join(asset_dir, "..latest")
+    err = os.WriteFile(lat_path, []byte("{ \"version\": \"3\" }"), 0644)
+    if err != nil {
+        t.Fatalf("failed to write to the ..latest file; %v", err)
+    }
+
+    names := []string{ "metadata.json" }
+    client := getSewerRatClient()
+
+    // Registering multiple versions.
+    {
+        err := ignoreNonLatest(context.Background(), client, asset_dir, indexPolicy{ Names: names, Retention: latestCountRetention{ Count: 2 } }, false)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), asset_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        sort.Strings(found)
+        if len(found) != 2 || found[0] != "2" || found[1] != "3" {
+            t.Errorf("expected versions '2' and '3' to be registered; %v", found)
+        }
+    }
+
+    // Switching to all versions.
+    {
+        err := ignoreNonLatest(context.Background(), client, asset_dir, indexPolicy{ Names: names, Retention: allVersionsRetention{} }, false)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), asset_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 3 {
+            t.Errorf("expected all versions to be registered; %v", found)
+        }
+    }
+
+    // Restricting to an allow-list.
+    {
+        err := ignoreNonLatest(context.Background(), client, asset_dir, indexPolicy{ Names: names, Retention: allowListRetention{ Versions: []string{ "1" } } }, false)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), asset_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 1 || found[0] != "1" {
+            t.Errorf("expected only version '1' to be registered; %v", found)
+        }
+    }
+}
+
+func TestIgnoreNonLatestProbation(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    asset_dir := filepath.Join(registry, "liella", "chisato")
+    err = mockVersionsWithSummaries(asset_dir, map[string]string{
+        "1": "2021-01-21T02:22:22Z",
+        "2": "2022-02-22T02:22:22Z",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.MkdirAll(filepath.Join(asset_dir, "3"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(asset_dir, "3", "..summary"), []byte("{ \"upload_finish\": \"2023-03-23T03:33:33Z\", \"on_probation\": true }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(asset_dir, "..latest"), []byte("{ \"version\": \"3\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    latest, err := chooseLatestVersion(asset_dir, "3")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest != "2" {
+        t.Errorf("expected a fallback to the newest non-probational version; %q", latest)
+    }
+    latest, err = chooseLatestVersion(asset_dir, "1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if latest != "1" {
+        t.Errorf("expected non-probational versions to be used directly; %q", latest)
+    }
+
+    names := []string{ "metadata.json" }
+    client := getSewerRatClient()
+    ctx := context.Background()
+
+    for _, policy := range []retentionPolicy{ latestOnlyRetention{}, allowListRetention{ Versions: []string{ "2", "3" } } } {
+        err := ignoreNonLatest(ctx, client, asset_dir, indexPolicy{ Names: names, Retention: policy }, false)
+        if err != nil {
+            t.Fatal(err)
+        }
+        found, err := client.ListRegisteredSubdirectories(ctx, asset_dir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 1 || found[0] != "2" {
+            t.Errorf("expected only version '2' to be registered for %T; %v", policy, found)
+        }
+    }
+
+    // Nothing is registered if all versions are probational.
+    for _, ver := range []string{ "1", "2" } {
+        err := os.WriteFile(filepath.Join(asset_dir, ver, "..summary"), []byte("{ \"upload_finish\": \"2023-03-23T03:33:33Z\", \"on_probation\": true }"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    err = ignoreNonLatest(ctx, client, asset_dir, indexPolicy{ Names: names, Retention: latestOnlyRetention{} }, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    found, err := client.ListRegisteredSubdirectories(ctx, asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 0 {
+        t.Errorf("expected no versions to be registered when all are probational; %v", found)
+    }
+}
+
+func TestHasMetadataFiles(t *testing.T) {
+    version_dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    names := []string{ "metadata.json", "other.json" }
+
+    found, err := hasMetadataFiles(version_dir, names)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if found {
+        t.Error("expected no metadata files in an empty directory")
+    }
+
+    err = os.MkdirAll(filepath.Join(version_dir, "foo", "bar"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(version_dir, "foo", "whee.json"), []byte("{}"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    found, err = hasMetadataFiles(version_dir, names)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if found {
+        t.Error("expected no metadata files when none of the names match")
+    }
+
+    err = os.WriteFile(filepath.Join(version_dir, "foo", "bar", "other.json"), []byte("{}"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    found, err = hasMetadataFiles(version_dir, names)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !found {
+        t.Error("expected metadata files to be found in a subdirectory")
+    }
+
+    _, err = hasMetadataFiles(filepath.Join(version_dir, "missing"), names)
+    if err == nil {
+        t.Error("expected a failure for a missing directory")
+    }
+}
+
+func TestIgnoreNonLatestCheckNames(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    asset_dir := filepath.Join(registry, "liella", "ren")
+    version_dir := filepath.Join(asset_dir, "1")
+    err = os.MkdirAll(version_dir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(asset_dir, "..latest"), []byte("{ \"version\": \"1\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := getSewerRatClient()
+    ctx := context.Background()
+    policy := indexPolicy{ Names: []string{ "metadata.json" }, Retention: latestOnlyRetention{}, CheckNames: true }
+
+    err = ignoreNonLatest(ctx, client, asset_dir, policy, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    found, err := client.ListRegisteredSubdirectories(ctx, asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 0 {
+        t.Errorf("expected a version without metadata files to be skipped; %v", found)
+    }
+
+    err = os.WriteFile(filepath.Join(version_dir, "metadata.json"), []byte("{}"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = ignoreNonLatest(ctx, client, asset_dir, policy, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    found, err = client.ListRegisteredSubdirectories(ctx, asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0] != "1" {
+        t.Errorf("expected a version with metadata files to be registered; %v", found)
+    }
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+    "os"
+    "bufio"
+    "strings"
+    "time"
+    "fmt"
+    "errors"
+)
+
+// How long to remember the names of processed logs.
+// Logs that are older than the newest processed log by more than this duration are assumed to be processed, so their names can be forgotten.
+// This limits the growth of the ledger while still allowing for logs that are written late.
+const ledgerRetention = 7 * 24 * time.Hour
+
+const ledgerCutoffPrefix = "cutoff "
+
+// A logLedger records the names of the log files that have already been processed.
+// This is stored as an append-only file where each line is the name of a processed log file.
+// The file may also contain a line of the form 'cutoff <RFC3339>', indicating that all logs with earlier or equal timestamps were processed.
+type logLedger struct {
+    Path string
+    Cutoff time.Time
+    Processed map[string]bool
+    Marked int // number of logs marked as processed since the ledger was opened.
+    Newest time.Time // timestamp of the newest log in the log directory, as of the last listing by listPendingLogs().
+    handle *os.File
+}
+
+// Opens an existing ledger at 'ledger_path', or creates a new one with the specified cutoff if the ledger does not exist.
+func openLogLedger(ledger_path string, default_cutoff time.Time) (*logLedger, error) {
+    output := &logLedger{
+        Path: ledger_path,
+        Cutoff: default_cutoff,
+        Processed: map[string]bool{},
+    }
+
+    handle, err := os.Open(ledger_path)
+    if err == nil {
+        defer handle.Close()
+        output.Cutoff = time.Time{}
+        scanner := bufio.NewScanner(handle)
+        for scanner.Scan() {
+            line := scanner.Text()
+            if line == "" {
+                continue
+            }
+            if strings.HasPrefix(line, ledgerCutoffPrefix) {
+                cutoff, err := time.Parse(time.RFC3339, strings.TrimPrefix(line, ledgerCutoffPrefix))
+                if err != nil {
+                    return nil, fmt.Errorf("failed to parse the cutoff in %q; %w", ledger_path, err)
+                }
+                if cutoff.After(output.Cutoff) {
+                    output.Cutoff = cutoff
+                }
+                continue
+            }
+            output.Processed[line] = true
+        }
+        if err := scanner.Err(); err != nil {
+            return nil, fmt.Errorf("failed to read %q; %w", ledger_path, err)
+        }
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("failed to open %q; %w", ledger_path, err)
+    }
+
+    // Rewriting the ledger to remove any old entries, which also creates the file if it didn't already exist.
+    err = output.compact()
+    if err != nil {
+        return nil, err
+    }
+    return output, nil
+}
+
+// Whether the log file with the specified name and timestamp was already processed.
+func (l *logLedger) isProcessed(name string, stamp time.Time) bool {
+    if !stamp.After(l.Cutoff) {
+        return true
+    }
+    return l.Processed[name]
+}
+
+// Records a log file as processed.
+// Note that the record is not guaranteed to be on disk until sync() is called.
+func (l *logLedger) markProcessed(name string) error {
+    if l.Processed[name] {
+        return nil
+    }
+    _, err := l.handle.WriteString(name + "\n")
+    if err != nil {
+        return fmt.Errorf("failed to add %q to the ledger at %q; %w", name, l.Path, err)
+    }
+    l.Processed[name] = true
+    l.Marked++
+    return nil
+}
+
+func (l *logLedger) sync() error {
+    err := l.handle.Sync()
+    if err != nil {
+        return fmt.Errorf("failed to sync the ledger at %q; %w", l.Path, err)
+    }
+    return nil
+}
+
+// Advances the cutoff to 'ledgerRetention' before the newest processed log, forgets all logs before the new cutoff, and rewrites the ledger.
+// This is a no-op if the ledger is already open and there are no logs to forget.
+func (l *logLedger) compact() error {
+    newest := time.Time{}
+    for name := range l.Processed {
+        stamp, err := parseLogTime(name)
+        if err == nil && stamp.After(newest) {
+            newest = stamp
+        }
+    }
+    changed := false
+    if cutoff := newest.Add(-ledgerRetention); cutoff.After(l.Cutoff) {
+        l.Cutoff = cutoff
+        changed = true
+    }
+
+    for name := range l.Processed {
+        stamp, err := parseLogTime(name)
+        if err == nil && !stamp.After(l.Cutoff) {
+            delete(l.Processed, name)
+            changed = true
+        }
+    }
+
+    if !changed && l.handle != nil {
+        return nil
+    }
+
+    // Writing to a temporary file and then moving it over, to avoid corrupting the ledger upon interruption.
+    temp_path := l.Path + ".tmp"
+    temp, err := os.OpenFile(temp_path, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to create a temporary ledger at %q; %w", temp_path, err)
+    }
+
+    writer := bufio.NewWriter(temp)
+    fmt.Fprintf(writer, "%s%s\n", ledgerCutoffPrefix, l.Cutoff.Format(time.RFC3339))
+    for name := range l.Processed {
+        fmt.Fprintf(writer, "%s\n", name)
+    }
+    err = writer.Flush()
+    if err == nil {
+        err = temp.Sync()
+    }
+    temp.Close()
+    if err != nil {
+        return fmt.Errorf("failed to write a temporary ledger at %q; %w", temp_path, err)
+    }
+
+    if l.handle != nil {
+        l.handle.Close()
+        l.handle = nil
+    }
+    err = os.Rename(temp_path, l.Path)
+    if err != nil {
+        return fmt.Errorf("failed to replace the ledger at %q; %w", l.Path, err)
+    }
+
+    l.handle, err = os.OpenFile(l.Path, os.O_APPEND | os.O_WRONLY, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to open the ledger at %q; %w", l.Path, err)
+    }
+    return nil
+}
+
+func (l *logLedger) close() error {
+    if l.handle == nil {
+        return nil
+    }
+    err := l.handle.Close()
+    l.handle = nil
+    if err != nil {
+        return fmt.Errorf("failed to close the ledger at %q; %w", l.Path, err)
+    }
+    return nil
+}
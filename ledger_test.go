@@ -0,0 +1,129 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "strings"
+    "time"
+)
+
+func TestLogLedger(t *testing.T) {
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create working directory; %v", err)
+    }
+    ledger_path := filepath.Join(workdir, "ledger")
+
+    cutoff, err := time.Parse(time.RFC3339, "2021-01-21T02:22:22Z")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Creating a new ledger.
+    {
+        ledger, err := openLogLedger(ledger_path, cutoff)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !ledger.Cutoff.Equal(cutoff) || len(ledger.Processed) != 0 {
+            t.Errorf("unexpected contents of a new ledger; %v", ledger)
+        }
+
+        if !ledger.isProcessed("2020-02-22T02:22:22Z_111111", time.Date(2020, 2, 22, 2, 22, 22, 0, time.UTC)) {
+            t.Error("logs before the cutoff should be processed")
+        }
+        if !ledger.isProcessed("2021-01-21T02:22:22Z_111111", cutoff) {
+            t.Error("logs at the cutoff should be processed")
+        }
+        if ledger.isProcessed("2022-02-22T02:22:22Z_111111", time.Date(2022, 2, 22, 2, 22, 22, 0, time.UTC)) {
+            t.Error("logs after the cutoff should not be processed")
+        }
+
+        for _, name := range []string{ "2022-02-22T02:22:22Z_111111", "2022-02-22T02:22:22Z_222222" } {
+            err = ledger.markProcessed(name)
+            if err != nil {
+                t.Fatal(err)
+            }
+        }
+        err = ledger.sync()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !ledger.isProcessed("2022-02-22T02:22:22Z_111111", time.Date(2022, 2, 22, 2, 22, 22, 0, time.UTC)) {
+            t.Error("marked logs should be processed")
+        }
+        if ledger.isProcessed("2022-02-22T02:22:22Z_333333", time.Date(2022, 2, 22, 2, 22, 22, 0, time.UTC)) {
+            t.Error("unmarked logs with the same timestamp should not be processed")
+        }
+
+        err = ledger.close()
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // Reopening the ledger ignores the default cutoff.
+    {
+        ledger, err := openLogLedger(ledger_path, time.Now())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(ledger.Processed) != 2 || !ledger.Processed["2022-02-22T02:22:22Z_222222"] {
+            t.Errorf("unexpected contents of a reopened ledger; %v", ledger)
+        }
+        if !ledger.Cutoff.Equal(time.Date(2022, 2, 22, 2, 22, 22, 0, time.UTC).Add(-ledgerRetention)) { // automatically compacted upon opening.
+            t.Errorf("unexpected cutoff for a reopened ledger; %v", ledger.Cutoff)
+        }
+
+        // Adding a much newer log, and compacting to forget the older logs.
+        err = ledger.markProcessed("2023-03-23T03:33:33Z_111111")
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = ledger.compact()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(ledger.Processed) != 1 || !ledger.Processed["2023-03-23T03:33:33Z_111111"] {
+            t.Errorf("unexpected processed logs after compaction; %v", ledger.Processed)
+        }
+        if !ledger.Cutoff.Equal(time.Date(2023, 3, 23, 3, 33, 33, 0, time.UTC).Add(-ledgerRetention)) {
+            t.Errorf("unexpected cutoff after compaction; %v", ledger.Cutoff)
+        }
+        if !ledger.isProcessed("2022-02-22T02:22:22Z_333333", time.Date(2022, 2, 22, 2, 22, 22, 0, time.UTC)) {
+            t.Error("forgotten logs should still be processed")
+        }
+
+        // Checking that appending still works after compaction.
+        err = ledger.markProcessed("2023-03-23T03:33:33Z_222222")
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = ledger.close()
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        reopened, err := openLogLedger(ledger_path, time.Now())
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer reopened.close()
+        if !reopened.Cutoff.Equal(ledger.Cutoff) || len(reopened.Processed) != 2 || !reopened.Processed["2023-03-23T03:33:33Z_222222"] {
+            t.Errorf("unexpected contents of a reopened ledger; %v", reopened)
+        }
+    }
+
+    // Fails for invalid cutoffs.
+    {
+        err := os.WriteFile(ledger_path, []byte("cutoff foobar\n"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, err = openLogLedger(ledger_path, time.Now())
+        if err == nil || !strings.Contains(err.Error(), "cutoff") {
+            t.Error("expected an error for an invalid cutoff")
+        }
+    }
+}
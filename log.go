@@ -8,12 +8,19 @@ import (
     "fmt"
     "strings"
     "errors"
+    "io"
+    "sort"
+    "context"
+    "log/slog"
+    "github.com/ArtifactDB/sayoko/sewerrat"
 )
 
+// Contents of a Gobbler log file, of which only the fields that are needed for reconciliation are parsed.
 type logEntry struct {
     Type string `json:"type"`
     Project string `json:"project"`
     Asset string `json:"asset"`
+    Version string `json:"version"`
 }
 
 func readLog(logpath string) (logEntry, error) {
@@ -34,84 +41,302 @@ func readLog(logpath string) (logEntry, error) {
     return output, nil
 }
 
-func processLogs(rest_url string, registry string, names []string, last_scan time.Time) (time.Time, error) {
-    lpath := filepath.Join(registry, "..logs")
+// Log file names are of the form '<RFC3339>_<ID>'.
+func parseLogTime(name string) (time.Time, error) {
+    pos := strings.IndexByte(name, '_')
+    if pos < 0 {
+        return time.Time{}, fmt.Errorf("failed to find the timestamp in %q", name)
+    }
+    stamp, err := time.Parse(time.RFC3339, name[:pos])
+    if err != nil {
+        return time.Time{}, fmt.Errorf("failed to parse time for %q; %w", name, err)
+    }
+    return stamp, nil
+}
+
+// Number of names to read from the log directory at a time.
+const logBatchSize = 1000
+
+// Number of logs to process at a time.
+// The targets of all logs in a chunk are coalesced before reconciliation, and the ledger is synced to disk after each chunk.
+const logChunkSize = 100
+
+// Calls 'fun' on the name of each file in the log directory at 'lpath'.
+// Names are read in batches to avoid holding the entire listing in memory, as the log directory grows indefinitely.
+func forEachLogName(lpath string, fun func(name string)) error {
     dirhandle, err := os.Open(lpath)
     if err != nil {
-        return last_scan, fmt.Errorf("failed to open directory handle for %q; %w", lpath, err)
+        return fmt.Errorf("failed to open directory handle for %q; %w", lpath, err)
     }
     defer dirhandle.Close()
 
-    lognames, err := dirhandle.Readdirnames(0)
-    if err != nil {
-        return last_scan, fmt.Errorf("failed to read log directory at %q; %w", lpath, err)
+    for {
+        lognames, err := dirhandle.Readdirnames(logBatchSize)
+        for _, n := range lognames {
+            fun(n)
+        }
+        if err != nil {
+            if errors.Is(err, io.EOF) {
+                return nil
+            }
+            return fmt.Errorf("failed to read log directory at %q; %w", lpath, err)
+        }
     }
+}
+
+type pendingLog struct {
+    Name string
+    Time time.Time
+}
 
+// Lists all logs in the log directory at 'lpath' that are not yet in the ledger, sorted by their timestamps (and then by name).
+// Logs with names that cannot be parsed are reported as errors.
+// The timestamp of the newest log is also recorded in the ledger, regardless of whether it was already processed.
+func listPendingLogs(lpath string, ledger *logLedger) ([]pendingLog, []error) {
+    pending := []pendingLog{}
     all_errors := []error{}
-    latest := last_scan
-
-    // No need to process things in order as long as we get everything past the last scan's timepoint;
-    // all directories will converge to being registered or not, so it doesn't matter.
-    for _, n := range lognames {
-        pos := strings.IndexByte(n, '_')
-        if pos < 0 {
-            all_errors = append(all_errors, fmt.Errorf("failed to parse time for %q; %w", n, err))
-            continue 
-        }
-        stamp_str := n[:pos]
-        stamp, err := time.Parse(time.RFC3339, stamp_str)
+    err := forEachLogName(lpath, func(n string) {
+        stamp, err := parseLogTime(n)
         if err != nil {
-            all_errors = append(all_errors, fmt.Errorf("failed to parse time for %q; %w", n, err))
-            continue
+            slog.Warn("failed to parse the log file name", "log", n, "error", err)
+            all_errors = append(all_errors, err)
+            return
         }
-        if !stamp.After(last_scan) {
-            continue
+        if stamp.After(ledger.Newest) {
+            ledger.Newest = stamp
+        }
+        if !ledger.isProcessed(n, stamp) {
+            pending = append(pending, pendingLog{ Name: n, Time: stamp })
+        }
+    })
+    if err != nil {
+        return nil, []error{ err }
+    }
+
+    sort.Slice(pending, func(i, j int) bool {
+        if pending[i].Time.Equal(pending[j].Time) {
+            return pending[i].Name < pending[j].Name
         }
-        if stamp.After(latest) {
-            latest = stamp
+        return pending[i].Time.Before(pending[j].Time)
+    })
+    return pending, all_errors
+}
+
+// Coalesces the targets of multiple logs so that each project/asset is only reconciled once.
+// Targets are ordered by their last occurrence, and are forcibly reindexed if any of the logs requested it.
+func coalesceTargets(targets []reconcileTarget) []reconcileTarget {
+    last := map[string]int{}
+    force := map[string]bool{}
+    for i, target := range targets {
+        key := target.String()
+        last[key] = i
+        force[key] = force[key] || target.Force
+    }
+
+    output := []reconcileTarget{}
+    for i, target := range targets {
+        key := target.String()
+        if last[key] == i {
+            target.Force = force[key]
+            output = append(output, target)
         }
+    }
+    return output
+}
 
-        logpath := filepath.Join(lpath, n)
+type chunkLog struct {
+    Name string
+    Type string
+    Targets []reconcileTarget
+    Valid bool
+    Logger *slog.Logger
+}
+
+// Processes a chunk of logs, reconciling the coalesced targets and then marking all logs in the chunk as processed.
+// If the processing is cancelled, no logs in the chunk are marked, so that they will be handled again upon restart.
+func processLogChunk(ctx context.Context, client *sewerrat.Client, registry string, config *indexConfig, ledger *logLedger, retries *retryQueue, chunk []pendingLog) error {
+    lpath := filepath.Join(registry, "..logs")
+    all_errors := []error{}
+    logs := []chunkLog{}
+    all_targets := []reconcileTarget{}
+
+    for _, entry := range chunk {
+        // If we can't read the log, we don't mark it as processed, as the Gobbler might still be writing it.
+        // This means that it will be retried during the next scan.
+        logpath := filepath.Join(lpath, entry.Name)
         payload, err := readLog(logpath)
         if err != nil {
+            slog.Warn("failed to read the log file", "log", entry.Name, "error", err)
             all_errors = append(all_errors, err)
             continue
         }
+        current := chunkLog{
+            Name: entry.Name,
+            Type: payload.Type,
+            Logger: slog.With("log", entry.Name, "type", payload.Type, "project", payload.Project, "asset", payload.Asset, "version", payload.Version),
+        }
 
-        if payload.Type == "add-version" || payload.Type == "delete-version" || payload.Type == "reindex-version" {
-            if payload.Project == "" || payload.Asset == "" {
-                all_errors = append(all_errors, fmt.Errorf("empty project/asset fields in %q", logpath))
-                continue
-            }
-            err := ignoreNonLatest(
-                rest_url,
-                filepath.Join(registry, payload.Project, payload.Asset),
-                names,
-                (payload.Type == "reindex-version"), // Immediately pick up any changes from reindexing.
-            )
+        targets, err := chooseLogTargets(payload, logpath)
+        if err != nil {
+            current.Logger.Error("invalid log file", "error", err)
             all_errors = append(all_errors, err)
+        } else if !knownLogTypes[payload.Type] {
+            current.Logger.Warn("ignoring log file with an unknown type")
+        } else {
+            current.Targets = targets
+            current.Valid = true
+            all_targets = append(all_targets, targets...)
+        }
+        logs = append(logs, current)
+    }
+
+    coalesced := coalesceTargets(all_targets)
+    if len(coalesced) < len(all_targets) {
+        slog.Debug("coalesced log targets", "logs", len(logs), "targets", len(all_targets), "reconciliations", len(coalesced))
+    }
 
-        } else if payload.Type == "delete-asset" {
-            if payload.Project == "" || payload.Asset == "" {
-                all_errors = append(all_errors, fmt.Errorf("empty project/asset fields in %q", logpath))
-                continue
+    failed := map[string]bool{}
+    for _, target := range coalesced {
+        err := reconcile(ctx, client, registry, config, target)
+        if ctx.Err() != nil {
+            all_errors = append(all_errors, fmt.Errorf("log processing was cancelled; %w", ctx.Err()))
+            return errors.Join(all_errors...)
+        }
+        if err == nil {
+            continue
+        }
+
+        failed[target.String()] = true
+        all_errors = append(all_errors, err)
+        logger := slog.With("project", target.Project, "asset", target.Asset)
+        if isRetryable(err) {
+            logger.Error("failed to reconcile the log target; scheduling a retry", "error", err)
+            err = retries.push(target)
+            if err != nil {
+                all_errors = append(all_errors, err)
             }
-            err := deregisterAllSubdirectories(rest_url, filepath.Join(registry, payload.Project, payload.Asset))
-            all_errors = append(all_errors, err)
+        } else {
+            logger.Error("permanently failed to reconcile the log target", "error", err)
+        }
+    }
 
-        } else if payload.Type == "delete-project" {
-            if payload.Project == "" {
-                all_errors = append(all_errors, fmt.Errorf("empty project field in %q", logpath))
-                continue
+    for _, current := range logs {
+        if current.Valid {
+            ok := true
+            for _, target := range current.Targets {
+                if failed[target.String()] {
+                    ok = false
+                }
+            }
+            if ok {
+                current.Logger.Info("processed log file")
             }
-            err := deregisterAllSubdirectories(rest_url, filepath.Join(registry, payload.Project))
+        }
+
+        observeLog(current.Type)
+        err := ledger.markProcessed(current.Name)
+        if err != nil {
+            all_errors = append(all_errors, err)
+        }
+    }
+
+    return errors.Join(all_errors...)
+}
+
+// Processes all logs that are not yet in the ledger, in order of their timestamps.
+// Logs are processed in chunks where multiple logs for the same project/asset only require a single reconciliation.
+// If the reconciliation for a log fails, the target is added to the retry queue so that it can be reconciled later.
+// The ledger is synced after each chunk so that an interrupted check does not need to process the same logs again.
+func processLogs(ctx context.Context, client *sewerrat.Client, registry string, config *indexConfig, ledger *logLedger, retries *retryQueue) error {
+    lpath := filepath.Join(registry, "..logs")
+    pending, all_errors := listPendingLogs(lpath, ledger)
+
+    for start := 0; start < len(pending); start += logChunkSize {
+        if err := ctx.Err(); err != nil {
+            all_errors = append(all_errors, fmt.Errorf("log processing was cancelled; %w", err))
+            break
+        }
+
+        err := processLogChunk(ctx, client, registry, config, ledger, retries, pending[start:min(start + logChunkSize, len(pending))])
+        if err != nil {
+            all_errors = append(all_errors, err)
+        }
+        if ctx.Err() != nil {
+            break
+        }
+
+        err = ledger.sync()
+        if err != nil {
             all_errors = append(all_errors, err)
         }
     }
 
     if len(all_errors) > 0 {
-        return latest, errors.Join(all_errors...)
+        return errors.Join(all_errors...)
     } else {
-        return latest, nil
+        return nil
     }
 }
+
+// Performs a single log check, i.e., processing all new logs, updating the ledger and retrying any failed reconciliations that are due.
+func checkLogs(ctx context.Context, client *sewerrat.Client, registry string, config *indexConfig, ledger *logLedger, retries *retryQueue) error {
+    all_errors := []error{}
+
+    err := processLogs(ctx, client, registry, config, ledger, retries)
+    if err != nil {
+        all_errors = append(all_errors, err)
+    }
+
+    err = ledger.sync()
+    if err == nil {
+        err = ledger.compact()
+    }
+    if err != nil {
+        slog.Error("failed to update the ledger", "error", err)
+        all_errors = append(all_errors, fmt.Errorf("failed to update the ledger; %w", err))
+    }
+
+    err = processRetries(ctx, client, registry, config, retries, time.Now())
+    if err != nil {
+        all_errors = append(all_errors, err)
+    }
+
+    if len(all_errors) > 0 {
+        return errors.Join(all_errors...)
+    } else {
+        return nil
+    }
+}
+
+// Chooses the projects/assets to reconcile for a log.
+// Only the log types that are known to be emitted by the Gobbler are handled, i.e., those in knownLogTypes;
+// this returns an empty slice for any other type.
+func chooseLogTargets(payload logEntry, logpath string) ([]reconcileTarget, error) {
+    switch payload.Type {
+    case "add-version", "delete-version", "reindex-version":
+        if payload.Project == "" || payload.Asset == "" {
+            return nil, fmt.Errorf("empty project/asset fields in %q", logpath)
+        }
+        return []reconcileTarget{
+            reconcileTarget{
+                Project: payload.Project,
+                Asset: payload.Asset,
+                Force: (payload.Type == "reindex-version"), // Immediately pick up any changes from reindexing.
+            },
+        }, nil
+
+    case "delete-asset":
+        if payload.Project == "" || payload.Asset == "" {
+            return nil, fmt.Errorf("empty project/asset fields in %q", logpath)
+        }
+        return []reconcileTarget{ reconcileTarget{ Project: payload.Project, Asset: payload.Asset } }, nil
+
+    case "delete-project":
+        if payload.Project == "" {
+            return nil, fmt.Errorf("empty project field in %q", logpath)
+        }
+        return []reconcileTarget{ reconcileTarget{ Project: payload.Project } }, nil
+    }
+
+    return []reconcileTarget{}, nil
+}
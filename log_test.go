@@ -1,12 +1,18 @@
 package main
 
 import (
+    "context"
     "testing"
     "os"
     "time"
     "path/filepath"
     "sort"
     "strings"
+    "slices"
+    "fmt"
+    "github.com/ArtifactDB/sayoko/gobblertest"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+    "github.com/ArtifactDB/sayoko/sewerrat/sewerrattest"
 )
 
 func TestReadLog(t *testing.T) {
@@ -80,8 +86,32 @@ func TestProcessLogs(t *testing.T) {
         t.Fatalf("failed to parse time; %v", err)
     }
 
-    url := getSewerRatUrl()
+    // Using a fresh ledger for each call so that re-used log names are processed again.
+    ledger_dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create ledger directory; %v", err)
+    }
+    ledger_path := filepath.Join(ledger_dir, "ledger")
+    newLedger := func() *logLedger {
+        err := os.RemoveAll(ledger_path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ledger, err := openLogLedger(ledger_path, last_scan)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return ledger
+    }
+
+    retries, err := openRetryQueue(filepath.Join(ledger_dir, "retries"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := getSewerRatClient()
     names := []string{ "metadata.json" }
+    config := newIndexConfig(names, latestOnlyRetention{})
 
     // Adding a new version.
     {
@@ -99,12 +129,12 @@ func TestProcessLogs(t *testing.T) {
             t.Fatalf("failed to create a new log file; %v", err)
         }
 
-        _, err = processLogs(url, registry, names, last_scan)
+        err = processLogs(context.Background(), client, registry, config, newLedger(), retries)
         if err != nil {
             t.Fatal(err)
         }
 
-        found, err := listRegisteredSubdirectories(url, registry)
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
         if err != nil {
             t.Fatal(err)
         }
@@ -123,7 +153,7 @@ func TestProcessLogs(t *testing.T) {
         if err != nil {
             t.Fatal(err)
         }
-        err = registerDirectory(url, whee_path, names)
+        err = client.RegisterDirectory(context.Background(), whee_path, names)
         if err != nil {
             t.Fatal(err)
         }
@@ -133,7 +163,7 @@ func TestProcessLogs(t *testing.T) {
         }
 
         // Confirming that we registered it successfully.
-        found, err := listRegisteredSubdirectories(url, filepath.Join(registry, "foo", "whee"))
+        found, err := client.ListRegisteredSubdirectories(context.Background(), filepath.Join(registry, "foo", "whee"))
         if err != nil {
             t.Fatal(err)
         }
@@ -147,12 +177,12 @@ func TestProcessLogs(t *testing.T) {
             t.Fatalf("failed to create a new log file; %v", err)
         }
 
-        _, err = processLogs(url, registry, names, last_scan)
+        err = processLogs(context.Background(), client, registry, config, newLedger(), retries)
         if err != nil {
             t.Fatal(err)
         }
 
-        found, err = listRegisteredSubdirectories(url, filepath.Join(registry, "foo"))
+        found, err = client.ListRegisteredSubdirectories(context.Background(), filepath.Join(registry, "foo"))
         if err != nil {
             t.Fatal(err)
         }
@@ -171,7 +201,7 @@ func TestProcessLogs(t *testing.T) {
             if err != nil {
                 t.Fatal(err)
             }
-            err = registerDirectory(url, whee_path, names)
+            err = client.RegisterDirectory(context.Background(), whee_path, names)
             if err != nil {
                 t.Fatal(err)
             }
@@ -182,7 +212,7 @@ func TestProcessLogs(t *testing.T) {
         }
 
         // Confirming that we registered it successfully.
-        found, err := listRegisteredSubdirectories(url, filepath.Join(registry, "shibuya", "aria"))
+        found, err := client.ListRegisteredSubdirectories(context.Background(), filepath.Join(registry, "shibuya", "aria"))
         if err != nil {
             t.Fatal(err)
         }
@@ -196,12 +226,12 @@ func TestProcessLogs(t *testing.T) {
             t.Fatalf("failed to create a new log file; %v", err)
         }
 
-        _, err = processLogs(url, registry, names, last_scan)
+        err = processLogs(context.Background(), client, registry, config, newLedger(), retries)
         if err != nil {
             t.Fatal(err)
         }
 
-        found, err = listRegisteredSubdirectories(url, filepath.Join(registry, "shibuya"))
+        found, err = client.ListRegisteredSubdirectories(context.Background(), filepath.Join(registry, "shibuya"))
         if err != nil {
             t.Fatal(err)
         }
@@ -219,7 +249,7 @@ func TestProcessLogs(t *testing.T) {
         if err != nil {
             t.Fatal(err)
         }
-        err = registerDirectory(url, whee_path, names)
+        err = client.RegisterDirectory(context.Background(), whee_path, names)
         if err != nil {
             t.Fatal(err)
         }
@@ -229,7 +259,7 @@ func TestProcessLogs(t *testing.T) {
         }
 
         // Confirming that we registered it successfully.
-        found, err := listRegisteredSubdirectories(url, filepath.Join(registry, "heanna"))
+        found, err := client.ListRegisteredSubdirectories(context.Background(), filepath.Join(registry, "heanna"))
         if err != nil {
             t.Fatal(err)
         }
@@ -243,12 +273,12 @@ func TestProcessLogs(t *testing.T) {
             t.Fatalf("failed to create a new log file; %v", err)
         }
 
-        _, err = processLogs(url, registry, names, last_scan)
+        err = processLogs(context.Background(), client, registry, config, newLedger(), retries)
         if err != nil {
             t.Fatal(err)
         }
 
-        found, err = listRegisteredSubdirectories(url, registry)
+        found, err = client.ListRegisteredSubdirectories(context.Background(), registry)
         if err != nil {
             t.Fatal(err)
         }
@@ -267,7 +297,7 @@ func TestProcessLogs(t *testing.T) {
         if err != nil {
             t.Fatalf("failed to create a new log file; %v", err)
         }
-        _, err = processLogs(url, registry, names, last_scan)
+        err = processLogs(context.Background(), client, registry, config, newLedger(), retries)
         if err == nil || !strings.Contains(err.Error(), "empty") {
             t.Error("lack of error when project field is empty")
         }
@@ -276,7 +306,7 @@ func TestProcessLogs(t *testing.T) {
         if err != nil {
             t.Fatalf("failed to create a new log file; %v", err)
         }
-        _, err = processLogs(url, registry, names, last_scan)
+        err = processLogs(context.Background(), client, registry, config, newLedger(), retries)
         if err == nil || !strings.Contains(err.Error(), "empty") {
             t.Error("lack of error when asset field is empty")
         }
@@ -285,7 +315,7 @@ func TestProcessLogs(t *testing.T) {
         if err != nil {
             t.Fatalf("failed to create a new log file; %v", err)
         }
-        _, err = processLogs(url, registry, names, last_scan)
+        err = processLogs(context.Background(), client, registry, config, newLedger(), retries)
         if err == nil || !strings.Contains(err.Error(), "empty") {
             t.Error("lack of error when asset field is empty")
         }
@@ -294,7 +324,7 @@ func TestProcessLogs(t *testing.T) {
         if err != nil {
             t.Fatalf("failed to create a new log file; %v", err)
         }
-        _, err = processLogs(url, registry, names, last_scan)
+        err = processLogs(context.Background(), client, registry, config, newLedger(), retries)
         if err == nil || !strings.Contains(err.Error(), "empty") {
             t.Error("lack of error when project field is empty")
         }
@@ -303,7 +333,7 @@ func TestProcessLogs(t *testing.T) {
     // Respects timestamps.
     {
         flushLogs()
-        err = deregisterAllSubdirectories(url, registry)
+        err = client.DeregisterAllSubdirectories(context.Background(), registry)
         if err != nil {
             t.Fatal(err)
         }
@@ -313,7 +343,7 @@ func TestProcessLogs(t *testing.T) {
         if err != nil {
             t.Fatal(err)
         }
-        err = registerDirectory(url, whee_path, names)
+        err = client.RegisterDirectory(context.Background(), whee_path, names)
         if err != nil {
             t.Fatal(err)
         }
@@ -323,7 +353,7 @@ func TestProcessLogs(t *testing.T) {
         }
 
         // Confirming that we registered it successfully.
-        found, err := listRegisteredSubdirectories(url, registry)
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
         if err != nil {
             t.Fatal(err)
         }
@@ -350,21 +380,370 @@ func TestProcessLogs(t *testing.T) {
             t.Fatalf("failed to create a new log file; %v", err)
         }
 
-        new_time, err := processLogs(url, registry, names, last_scan)
+        ledger := newLedger()
+        err = processLogs(context.Background(), client, registry, config, ledger, retries)
         if err != nil {
             t.Fatal(err)
         }
-        if new_time.Year() != 2024 {
-            t.Error("unexpected year from the latest timestamp")
+        if len(ledger.Processed) != 2 || !ledger.Processed["2022-02-22T02:22:22Z_111111"] || !ledger.Processed["2024-04-24T04:44:44Z_111111"] {
+            t.Errorf("unexpected logs in the ledger; %v", ledger.Processed)
         }
 
         // Confirming that we only updated the things past the last_scan timestamp.
-        found, err = listRegisteredSubdirectories(url, registry)
+        found, err = client.ListRegisteredSubdirectories(context.Background(), registry)
         if err != nil {
             t.Fatal(err)
         }
         if len(found) != 1 || found[0] != "foo/bar/1" {
             t.Fatalf("expected only 'foo/bar/1' to be registered; %v", found)
         }
+
+        // Re-using the same ledger skips the processed logs, but still picks up new logs with the same timestamp.
+        err = client.DeregisterAllSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        log_path = filepath.Join(logdir, "2024-04-24T04:44:44Z_222222")
+        err = os.WriteFile(log_path, []byte("{ \"type\": \"add-version\", \"project\": \"shibuya\", \"asset\": \"kanon\", \"version\": \"2\" }"), 0644)
+        if err != nil {
+            t.Fatalf("failed to create a new log file; %v", err)
+        }
+
+        err = processLogs(context.Background(), client, registry, config, ledger, retries)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(ledger.Processed) != 3 || !ledger.Processed["2024-04-24T04:44:44Z_222222"] {
+            t.Errorf("unexpected logs in the ledger; %v", ledger.Processed)
+        }
+
+        found, err = client.ListRegisteredSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 1 || found[0] != "shibuya/kanon/2" {
+            t.Fatalf("expected only 'shibuya/kanon/2' to be registered; %v", found)
+        }
+    }
+
+    // Cancelled processing does not mark any logs as processed.
+    {
+        flushLogs()
+
+        log_path := filepath.Join(logdir, "2022-02-22T02:22:22Z_111111")
+        err := os.WriteFile(log_path, []byte("{ \"type\": \"add-version\", \"project\": \"foo\", \"asset\": \"bar\", \"version\": \"1\" }"), 0644)
+        if err != nil {
+            t.Fatalf("failed to create a new log file; %v", err)
+        }
+
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+        ledger := newLedger()
+        err = processLogs(ctx, client, registry, config, ledger, retries)
+        if err == nil || !strings.Contains(err.Error(), "cancelled") {
+            t.Errorf("expected a cancellation error; %v", err)
+        }
+        if len(ledger.Processed) != 0 {
+            t.Errorf("no logs should be processed after cancellation; %v", ledger.Processed)
+        }
+    }
+
+    // Failed reconciliations are added to the retry queue.
+    {
+        flushLogs()
+
+        err := os.MkdirAll(filepath.Join(registry, "liella", "kinako"), 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = os.WriteFile(filepath.Join(registry, "liella", "kinako", "..latest"), []byte("{ \"version\": \"3\" }"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        log_path := filepath.Join(logdir, "2022-02-22T02:22:22Z_111111")
+        err = os.WriteFile(log_path, []byte("{ \"type\": \"reindex-version\", \"project\": \"liella\", \"asset\": \"kinako\", \"version\": \"3\" }"), 0644)
+        if err != nil {
+            t.Fatalf("failed to create a new log file; %v", err)
+        }
+
+        ledger := newLedger()
+        err = processLogs(context.Background(), client, registry, config, ledger, retries)
+        if err == nil || !strings.Contains(err.Error(), "does not exist") {
+            t.Error("expected a failure when reindexing a missing version")
+        }
+        if !ledger.Processed["2022-02-22T02:22:22Z_111111"] {
+            t.Error("expected the failed log to be marked as processed")
+        }
+        entry, found := retries.Entries["liella/kinako"]
+        if !found || !entry.Target.Force || entry.Attempts != 1 {
+            t.Errorf("expected the failed reconciliation to be added to the retry queue; %v", retries.Entries)
+        }
+    }
+}
+
+func TestChooseLogTargets(t *testing.T) {
+    for _, test := range []struct{
+        Payload logEntry
+        Expected []reconcileTarget
+    }{
+        {
+            logEntry{ Type: "add-version", Project: "foo", Asset: "bar", Version: "1" },
+            []reconcileTarget{ reconcileTarget{ Project: "foo", Asset: "bar" } },
+        },
+        {
+            logEntry{ Type: "reindex-version", Project: "foo", Asset: "bar", Version: "1" },
+            []reconcileTarget{ reconcileTarget{ Project: "foo", Asset: "bar", Force: true } },
+        },
+        {
+            logEntry{ Type: "delete-asset", Project: "foo", Asset: "bar" },
+            []reconcileTarget{ reconcileTarget{ Project: "foo", Asset: "bar" } },
+        },
+        {
+            logEntry{ Type: "delete-project", Project: "foo" },
+            []reconcileTarget{ reconcileTarget{ Project: "foo" } },
+        },
+        {
+            logEntry{ Type: "whee", Project: "foo", Asset: "bar" },
+            []reconcileTarget{},
+        },
+    } {
+        targets, err := chooseLogTargets(test.Payload, "foo")
+        if err != nil {
+            t.Errorf("unexpected failure for %q; %v", test.Payload.Type, err)
+        } else if !slices.Equal(targets, test.Expected) {
+            t.Errorf("unexpected targets for %q; %v", test.Payload.Type, targets)
+        }
+    }
+
+    for _, payload := range []logEntry{
+        logEntry{ Type: "add-version", Project: "foo" },
+        logEntry{ Type: "delete-asset", Asset: "bar" },
+        logEntry{ Type: "delete-project" },
+    } {
+        _, err := chooseLogTargets(payload, "foo")
+        if err == nil {
+            t.Errorf("expected a failure for an invalid %q log; %v", payload.Type, payload)
+        }
+    }
+}
+
+func TestProcessLogsUnknownType(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := getSewerRatClient()
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+    ctx := context.Background()
+    defer client.DeregisterAllSubdirectories(ctx, registry) // to avoid affecting other tests.
+
+    err = os.MkdirAll(filepath.Join(registry, "liella", "kanon", "1"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(registry, "liella", "kanon", "..latest"), []byte("{ \"version\": \"1\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    logdir := filepath.Join(registry, "..logs")
+    err = os.Mkdir(logdir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(logdir, "2022-02-22T02:22:22Z_111111"), []byte("{ \"type\": \"whee\", \"project\": \"liella\", \"asset\": \"kanon\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    ledger, err := openLogLedger(filepath.Join(workdir, "ledger"), time.Time{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ledger.close()
+    retries, err := openRetryQueue(filepath.Join(workdir, "retries"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = processLogs(ctx, client, registry, config, ledger, retries)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !ledger.Processed["2022-02-22T02:22:22Z_111111"] {
+        t.Error("expected logs with unknown types to be marked as processed")
+    }
+
+    found, err := client.ListRegisteredSubdirectories(ctx, registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 0 {
+        t.Errorf("expected logs with unknown types to be ignored; %v", found)
+    }
+}
+
+func TestListPendingLogs(t *testing.T) {
+    logdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Creating more logs than the batch size, to check that all batches are read.
+    base := time.Date(2022, 2, 22, 2, 22, 22, 0, time.UTC)
+    for i := 0; i < logBatchSize + 10; i++ {
+        name := fmt.Sprintf("%s_%06d", base.Add(-time.Duration(i) * time.Second).Format(time.RFC3339), i)
+        err := os.WriteFile(filepath.Join(logdir, name), []byte("{}"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    for _, name := range []string{ "2023-03-23T03:33:33+01:00_000002", "2023-03-23T03:33:33+01:00_000001", "whee" } {
+        err := os.WriteFile(filepath.Join(logdir, name), []byte("{}"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    ledger_dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    ledger, err := openLogLedger(filepath.Join(ledger_dir, "ledger"), time.Time{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ledger.close()
+
+    pending, errs := listPendingLogs(logdir, ledger)
+    if len(errs) != 1 || !strings.Contains(errs[0].Error(), "whee") {
+        t.Errorf("expected an error for the invalid log name; %v", errs)
+    }
+    if len(pending) != logBatchSize + 12 {
+        t.Fatalf("expected all valid logs to be pending; %d", len(pending))
+    }
+    for i := 1; i < len(pending); i++ {
+        if pending[i].Time.Before(pending[i - 1].Time) {
+            t.Fatalf("expected pending logs to be sorted by time; %v before %v", pending[i - 1], pending[i])
+        }
+    }
+    if pending[0].Name != fmt.Sprintf("%s_%06d", base.Add(-time.Duration(logBatchSize + 9) * time.Second).Format(time.RFC3339), logBatchSize + 9) {
+        t.Errorf("unexpected first log; %v", pending[0])
+    }
+    if pending[len(pending) - 2].Name != "2023-03-23T03:33:33+01:00_000001" || pending[len(pending) - 1].Name != "2023-03-23T03:33:33+01:00_000002" {
+        t.Errorf("expected ties to be broken by name; %v", pending[len(pending) - 2:])
+    }
+
+    // Processed logs are filtered out.
+    ledger.Cutoff = base
+    err = ledger.markProcessed("2023-03-23T03:33:33+01:00_000001")
+    if err != nil {
+        t.Fatal(err)
+    }
+    pending, _ = listPendingLogs(logdir, ledger)
+    if len(pending) != 1 || pending[0].Name != "2023-03-23T03:33:33+01:00_000002" {
+        t.Errorf("expected processed logs to be filtered out; %v", pending)
+    }
+    if !ledger.Newest.Equal(time.Date(2023, 3, 23, 2, 33, 33, 0, time.UTC)) {
+        t.Errorf("expected the newest log to be recorded, regardless of whether it was processed; %v", ledger.Newest)
+    }
+
+    _, errs = listPendingLogs(filepath.Join(logdir, "missing"), ledger)
+    if len(errs) != 1 {
+        t.Errorf("expected an error for a missing log directory; %v", errs)
+    }
+}
+
+func TestCoalesceTargets(t *testing.T) {
+    coalesced := coalesceTargets([]reconcileTarget{
+        reconcileTarget{ Project: "liella", Asset: "kanon", Force: true },
+        reconcileTarget{ Project: "liella", Asset: "keke" },
+        reconcileTarget{ Project: "liella" },
+        reconcileTarget{ Project: "liella", Asset: "kanon" },
+        reconcileTarget{ Project: "liella", Asset: "keke" },
+    })
+    expected := []reconcileTarget{
+        reconcileTarget{ Project: "liella" },
+        reconcileTarget{ Project: "liella", Asset: "kanon", Force: true },
+        reconcileTarget{ Project: "liella", Asset: "keke" },
+    }
+    if !slices.Equal(coalesced, expected) {
+        t.Errorf("unexpected coalesced targets; %v", coalesced)
+    }
+
+    if coalesced := coalesceTargets([]reconcileTarget{}); len(coalesced) != 0 {
+        t.Errorf("expected no targets; %v", coalesced)
+    }
+}
+
+func TestProcessLogsCoalesced(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    reg, err := gobblertest.Create(registry, gobblertest.GenerateSpec(1, 2, 1))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    server := sewerrattest.NewServer()
+    defer server.Close()
+    client := sewerrat.NewClient(server.URL)
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+    ctx := context.Background()
+
+    // Deleting and re-adding an asset, with multiple logs for the same asset.
+    finish := time.Now().UTC()
+    mutations := []func() (string, error){
+        func() (string, error) { return reg.DeleteAsset("project-0", "asset-0") },
+        func() (string, error) { return reg.AddVersion("project-0", "asset-0", gobblertest.Version{ Name: "2", Finish: finish }) },
+        func() (string, error) { return reg.AddVersion("project-0", "asset-0", gobblertest.Version{ Name: "3", Finish: finish.Add(time.Second) }) },
+        func() (string, error) { return reg.ReindexVersion("project-0", "asset-1", "1") },
+        func() (string, error) { return reg.AddVersion("project-0", "asset-1", gobblertest.Version{ Name: "2", Finish: finish }) },
+    }
+    for _, mut := range mutations {
+        _, err := mut()
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    ledger, err := openLogLedger(filepath.Join(workdir, "ledger"), time.Time{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ledger.close()
+    retries, err := openRetryQueue(filepath.Join(workdir, "retries"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = processLogs(ctx, client, registry, config, ledger, retries)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if ledger.Marked != len(mutations) {
+        t.Errorf("expected all logs to be marked as processed; %d", ledger.Marked)
+    }
+
+    // Only one listing per asset, rather than one per log.
+    if server.Requests("/registered") != 2 {
+        t.Errorf("expected the logs to be coalesced into one reconciliation per asset; %d", server.Requests("/registered"))
+    }
+
+    registered := server.Registered()
+    if len(registered) != 2 || registered[0] != filepath.Join(registry, "project-0", "asset-0", "3") || registered[1] != filepath.Join(registry, "project-0", "asset-1", "2") {
+        t.Errorf("expected the latest version of each asset to be registered; %v", registered)
     }
 }
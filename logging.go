@@ -0,0 +1,43 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "errors"
+    "log/slog"
+    "path/filepath"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+// Creates a logger that writes to 'w' in the specified format, i.e., "text" or "json".
+func newLogger(w io.Writer, format string) (*slog.Logger, error) {
+    if format == "text" {
+        return slog.New(slog.NewTextHandler(w, nil)), nil
+    } else if format == "json" {
+        return slog.New(slog.NewJSONHandler(w, nil)), nil
+    }
+    return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// Logs the (de)registration of a version of an asset, unless the client is in dry-run mode.
+func logVersionAction(client *sewerrat.Client, action string, version_dir string, err error) {
+    if client.DryRun != nil {
+        return
+    }
+    asset_dir := filepath.Dir(version_dir)
+    args := []any{
+        "action", action,
+        "project", filepath.Base(filepath.Dir(asset_dir)),
+        "asset", filepath.Base(asset_dir),
+        "version", filepath.Base(version_dir),
+    }
+    if err != nil {
+        var serr *sewerrat.Error
+        if errors.As(err, &serr) && serr.StatusCode != 0 {
+            args = append(args, "status", serr.StatusCode)
+        }
+        slog.Error("failed to update SewerRat", append(args, "error", err)...)
+    } else {
+        slog.Info("updated SewerRat", args...)
+    }
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "strings"
+    "testing"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+func TestNewLogger(t *testing.T) {
+    var buf bytes.Buffer
+    logger, err := newLogger(&buf, "json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    logger.Info("whee", "project", "foo", "asset", "bar")
+
+    decoded := map[string]interface{}{}
+    err = json.Unmarshal(buf.Bytes(), &decoded)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if decoded["msg"] != "whee" || decoded["project"] != "foo" || decoded["asset"] != "bar" {
+        t.Errorf("unexpected JSON log message; %s", buf.String())
+    }
+
+    buf.Reset()
+    logger, err = newLogger(&buf, "text")
+    if err != nil {
+        t.Fatal(err)
+    }
+    logger.Info("whee", "project", "foo")
+    if !strings.Contains(buf.String(), "msg=whee project=foo") {
+        t.Errorf("unexpected text log message; %s", buf.String())
+    }
+
+    _, err = newLogger(&buf, "xml")
+    if err == nil || !strings.Contains(err.Error(), "unknown") {
+        t.Error("expected an error for an unknown format")
+    }
+}
+
+func TestLogVersionAction(t *testing.T) {
+    var buf bytes.Buffer
+    logger, err := newLogger(&buf, "json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    old := slog.Default()
+    slog.SetDefault(logger)
+    defer slog.SetDefault(old)
+
+    client := sewerrat.NewClient("http://localhost")
+    logVersionAction(client, sewerrat.ActionRegister, "/registry/foo/bar/v1", errors.New("whee"))
+
+    decoded := map[string]interface{}{}
+    err = json.Unmarshal(buf.Bytes(), &decoded)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if decoded["level"] != "ERROR" || decoded["action"] != "register" || decoded["project"] != "foo" || decoded["asset"] != "bar" || decoded["version"] != "v1" || decoded["error"] != "whee" {
+        t.Errorf("unexpected log message; %s", buf.String())
+    }
+
+    // Nothing is logged in dry-run mode.
+    buf.Reset()
+    client.DryRun = &sewerrat.Plan{}
+    logVersionAction(client, sewerrat.ActionRegister, "/registry/foo/bar/v1", nil)
+    if buf.Len() != 0 {
+        t.Errorf("expected no log messages in dry-run mode; %s", buf.String())
+    }
+}
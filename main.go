@@ -3,13 +3,20 @@ package main
 import (
     "os"
     "flag"
-    "log"
+    "log/slog"
     "fmt"
     "time"
     "sync"
     "path/filepath"
     "errors"
     "strings"
+    "context"
+    "os/signal"
+    "syscall"
+    "net"
+    "net/http"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func retrieveLastScanTime(last_scan_path string) time.Time {
@@ -19,30 +26,48 @@ func retrieveLastScanTime(last_scan_path string) time.Time {
         if err == nil {
             return candidate
         } else {
-            log.Printf("failed to parse the last scan time; %v", err)
+            slog.Warn("failed to parse the last scan time", "path", last_scan_path, "error", err)
         }
     } else if !errors.Is(err, os.ErrNotExist) {
-        log.Printf("failed to read the last scan time; %v", err)
+        slog.Warn("failed to read the last scan time", "path", last_scan_path, "error", err)
     }
     return time.Now()
 }
 
-func depositLastScanTime(last_scan time.Time, last_scan_path string) {
-    err := os.WriteFile(last_scan_path, []byte(last_scan.Format(time.RFC3339)), 0644)
-    if err != nil {
-        log.Printf("failed to write the last scan time; %v", err)
-    }
-}
 
 func main() {
     gpath := flag.String("registry", "", "Path to the gobbler registry")
     surl := flag.String("url", "", "URL of the SewerRat instance")
+    timeout := flag.Int("timeout", int(sewerrat.DefaultTimeout / time.Second), "Timeout for each request to the SewerRat instance, in seconds")
     log_time := flag.Int("log", 10, "Interval in which to check for new logs, in minutes")
+    watch := flag.Bool("watch", true, "Whether to watch the log directory for new logs in between the regular log checks")
     full_time := flag.Int("full", 168, "Interval in which to do a full check, in hours")
-    tpath := flag.String("timestamp", ".sayoko_last_scan", "Path to the last scan timestamp")
+    concurrency := flag.Int("concurrency", 4, "Maximum number of assets to process in parallel during a full check")
+    tpath := flag.String("timestamp", ".sayoko_last_scan", "Path to the last scan timestamp, used to initialize the ledger if it does not exist")
+    lpath := flag.String("ledger", ".sayoko_ledger", "Path to the ledger of processed logs")
+    rpath := flag.String("retries", ".sayoko_retries", "Path to the queue of failed reconciliations to be retried")
     names_list := flag.String("names", "metadata.json", "Comma-separated list containing the names of metadata files.")
+    check_names := flag.Bool("checknames", false, "Whether to skip the registration of versions that do not contain any of the metadata files")
+    config_path := flag.String("config", "", "Path to a JSON file containing per-project and per-asset index policies")
+    include_list := flag.String("include", "", "Comma-separated list of PROJECT or PROJECT/ASSET patterns to include in the index")
+    exclude_list := flag.String("exclude", "", "Comma-separated list of PROJECT or PROJECT/ASSET patterns to exclude from the index")
+    retention := flag.String("retention", "latest", "Policy for choosing the versions of each asset to register, i.e., 'latest', 'latest:N', 'all' or 'versions:A,B,C'")
+    once := flag.String("once", "", "Run a single 'log' check or 'full' scan and exit, instead of running as a daemon")
+    metrics_address := flag.String("metrics", "", "Address (e.g., ':9090') on which to serve the Prometheus metrics at '/metrics' and the '/healthz' and '/readyz' probes, if any")
+    deadline := flag.Int("deadline", 0, "Time after which a running log check or full scan is considered to be stuck by the '/healthz' probe, in minutes (or 0, to disable)")
+    admin_address := flag.String("admin", "", "Address (e.g., 'localhost:8081') on which to serve the admin API, if any")
+    dry_run := flag.Bool("dryrun", false, "Print the actions of a full scan without registering or deregistering anything, and exit")
+    plan_format := flag.String("planformat", "table", "Format in which to print the actions for -dryrun, i.e., 'table' or 'json'")
+    log_format := flag.String("logformat", "text", "Format of the log messages, i.e., 'text' or 'json'")
     flag.Parse()
 
+    logger, err := newLogger(os.Stderr, *log_format)
+    if err != nil {
+        fmt.Println(err.Error())
+        os.Exit(1)
+    }
+    slog.SetDefault(logger)
+
     registry := *gpath
     rest_url := *surl
     if registry == "" || rest_url == "" {
@@ -55,38 +80,236 @@ func main() {
         os.Exit(1)
     }
 
+    if *once != "" && !isOnceMode(*once) {
+        fmt.Println("expected 'log' or 'full' for the one-shot mode")
+        os.Exit(1)
+    }
+
+    if *dry_run && *once != "" {
+        fmt.Println("cannot use '-dryrun' with '-once', as dry runs always perform a single full scan")
+        os.Exit(1)
+    }
+
+    client := sewerrat.NewClient(rest_url)
+    client.HTTPClient.Timeout = time.Duration(*timeout) * time.Second
+    client.Logger = logger
+
     names := strings.Split(*names_list, ",")
-    var lock sync.Mutex
+    policy, err := parseRetentionPolicy(*retention)
+    if err != nil {
+        fmt.Println(err.Error())
+        os.Exit(1)
+    }
+
+    config := newIndexConfig(names, policy)
+    config.Default.CheckNames = *check_names
+    if *include_list != "" {
+        config.Filter.Include, err = parseNamePatterns(strings.Split(*include_list, ","))
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+    }
+    if *exclude_list != "" {
+        config.Filter.Exclude, err = parseNamePatterns(strings.Split(*exclude_list, ","))
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+    }
+    if *config_path != "" {
+        err := config.loadConfigFile(*config_path)
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+    }
+
+    // Dry runs are performed before opening the ledger and retry queue, as they should not modify anything.
+    if *dry_run {
+        if *plan_format != "table" && *plan_format != "json" {
+            fmt.Println("expected 'table' or 'json' for the plan format")
+            os.Exit(1)
+        }
+        client.DryRun = &sewerrat.Plan{}
+        err := fullScan(context.Background(), client, registry, config, *concurrency)
+        if err != nil {
+            slog.Error("detected failures for dry run", "failures", countFailures(err))
+        }
+        perr := printPlan(os.Stdout, client.DryRun, *plan_format)
+        if perr != nil {
+            slog.Error("failed to print the plan", "error", perr)
+        }
+        if err != nil || perr != nil {
+            os.Exit(1)
+        }
+        return
+    }
+
+    // If the ledger doesn't exist yet, we assume that all logs up to the last scan (or now, if there was no last scan) were already processed.
+    ledger, err := openLogLedger(*lpath, retrieveLastScanTime(*tpath))
+    if err != nil {
+        fmt.Println(err.Error())
+        os.Exit(1)
+    }
+
+    retries, err := openRetryQueue(*rpath)
+    if err != nil {
+        fmt.Println(err.Error())
+        os.Exit(1)
+    }
+
+    // Stopping gracefully upon SIGINT or SIGTERM. Any in-flight requests to SewerRat are aborted (removing the verification file, if any),
+    // and the ledger and retry queue are flushed to disk before exiting.
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+
+    flush := func() bool {
+        ok := true
+        err := ledger.sync()
+        if err == nil {
+            err = ledger.close()
+        }
+        if err != nil {
+            slog.Error("failed to flush the ledger", "error", err)
+            ok = false
+        }
+        err = retries.save()
+        if err != nil {
+            slog.Error("failed to flush the retry queue", "error", err)
+            ok = false
+        }
+        return ok
+    }
+
+    if *once != "" {
+        summary, err := runOnce(ctx, *once, client, registry, config, ledger, retries, *concurrency)
+        if err != nil {
+            slog.Error("detected failures for one-shot run", "mode", *once, "failures", countFailures(err))
+        }
+        flushed := flush()
+        fmt.Println(summary.String())
+        if err != nil || !flushed {
+            os.Exit(1)
+        }
+        return
+    }
+
+    d := &daemon{
+        Client: client,
+        Registry: registry,
+        Config: config,
+        Ledger: ledger,
+        Retries: retries,
+        Concurrency: *concurrency,
+    }
+    var wg sync.WaitGroup
+
+    serve := func(address string, handler http.Handler, name string) {
+        server := &http.Server{ Addr: address, Handler: handler }
+        listener, err := net.Listen("tcp", address)
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            err := server.Serve(listener)
+            if err != nil && !errors.Is(err, http.ErrServerClosed) {
+                slog.Error("server failed", "server", name, "error", err)
+            }
+        }()
+
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            <-ctx.Done()
+            shutdown_ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+            defer cancel()
+            server.Shutdown(shutdown_ctx)
+        }()
+    }
+
+    // Setting the hooks before starting any server, as the admin API uses the same client.
+    if *metrics_address != "" {
+        client.OnRequest = observeRequest
+        client.OnAction = observeAction
+    }
+
+    // Optionally starting the admin API for on-demand reconciliation.
+    if *admin_address != "" {
+        serve(*admin_address, newAdminHandler(ctx, d), "admin API")
+    }
+
+    // Optionally serving metrics for Prometheus, along with the health probes.
+    if *metrics_address != "" {
+        mux := http.NewServeMux()
+        mux.Handle("GET /metrics", promhttp.HandlerFor(newMetricsRegistry(d), promhttp.HandlerOpts{}))
+        addHealthHandlers(mux, d, time.Duration(*deadline) * time.Minute)
+        serve(*metrics_address, mux, "metrics server")
+    }
 
     // Timer to inspect logs.
+    wg.Add(1)
     go func() {
-        last_scan_path := *tpath
-        last_scan := retrieveLastScanTime(last_scan_path)
+        defer wg.Done()
         timer := time.NewTicker(time.Minute * time.Duration(*log_time))
+        defer timer.Stop()
+
+        // Watching the log directory so that new logs are processed immediately.
+        // We still keep the timer around in case the filesystem doesn't support change notifications.
+        trigger := make(chan bool, 1)
+        if *watch {
+            stop, err := watchLogDirectory(filepath.Join(registry, "..logs"), time.Second, trigger)
+            if err != nil {
+                slog.Warn("falling back to regular log checks", "error", err)
+            } else {
+                defer stop()
+            }
+        }
+
         for {
-            lock.Lock()
-            new_last_scan, err := processLogs(rest_url, registry, names, last_scan)
-            lock.Unlock()
+            next_retry, has_retry, err := d.checkLogs(ctx)
             if err != nil {
-                log.Printf("detected failures for log check; %v", err)
+                slog.Error("detected failures for log check", "failures", countFailures(err))
+            }
+
+            // Waking up early if there are retries that need to be performed before the next log check.
+            var retry_wait <-chan time.Time
+            if has_retry {
+                retry_wait = time.After(time.Until(next_retry))
             }
-            if last_scan != new_last_scan { // new_last_scan can be used regardless of 'err'.
-                last_scan = new_last_scan
-                depositLastScanTime(last_scan, last_scan_path)
+            select {
+            case <-timer.C:
+            case <-trigger:
+            case <-retry_wait:
+            case <-ctx.Done():
+                return
             }
-            <-timer.C
         }
     }()
 
     // Timer to scan the entire registry.
     timer := time.NewTicker(time.Hour * time.Duration(*full_time))
+    defer timer.Stop()
     for {
-        lock.Lock()
-        err := fullScan(rest_url, registry, names)
-        lock.Unlock()
+        err := d.fullScan(ctx)
         if err != nil {
-            log.Printf("detected failures for log check; %v", err)
+            slog.Error("detected failures for full scan", "failures", countFailures(err))
+        }
+
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+        }
+        if ctx.Err() != nil {
+            break
         }
-        <-timer.C
     }
+
+    wg.Wait()
+    slog.Info("shutting down")
+    flush()
 }
@@ -1,14 +1,42 @@
 package main
 
 import (
+    "os"
     "time"
     "testing"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+    "github.com/ArtifactDB/sayoko/sewerrat/sewerrattest"
 )
 
+// URL of the SewerRat instance for testing, see sewerrattest.StartOrEnv() for details.
+var sewerratUrl string
+
+func TestMain(m *testing.M) {
+    url, stop := sewerrattest.StartOrEnv(3) // small pages to check that pagination is handled correctly.
+    sewerratUrl = url
+    code := m.Run()
+    stop()
+    removeBenchRegistries()
+    os.Exit(code)
+}
+
+func getSewerRatUrl() string {
+    return sewerratUrl
+}
+
+func getSewerRatClient() *sewerrat.Client {
+    return sewerrat.NewClient(getSewerRatUrl())
+}
+
 func TestLastScanTime(t *testing.T) {
     last_scan := time.Now()
     const last_scan_path = ".sayoko_last_scan"
-    depositLastScanTime(last_scan, last_scan_path)
+    err := os.WriteFile(last_scan_path, []byte(last_scan.Format(time.RFC3339)), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.Remove(last_scan_path)
+
     retrieved := retrieveLastScanTime(last_scan_path)
     if last_scan.Sub(retrieved).Abs() > time.Second { // needs some tolerance due to rounding of the stringified time.
         t.Fatalf("incorrect time value after a roundtrip (%v vs %v)", last_scan, retrieved)
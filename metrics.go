@@ -0,0 +1,153 @@
+package main
+
+import (
+    "time"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+var (
+    metricLogsProcessed = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "sayoko_logs_processed_total",
+            Help: "Number of Gobbler logs processed, by log type.",
+        },
+        []string{ "type" },
+    )
+
+    metricActionsAttempted = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "sayoko_actions_attempted_total",
+            Help: "Number of attempted registrations, reindexings and deregistrations.",
+        },
+        []string{ "action" },
+    )
+
+    metricActionsFailed = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "sayoko_actions_failed_total",
+            Help: "Number of failed registrations, reindexings and deregistrations, by whether the failure is permanent or transient.",
+        },
+        []string{ "action", "kind" },
+    )
+
+    metricLogCheckDuration = prometheus.NewHistogram(
+        prometheus.HistogramOpts{
+            Name: "sayoko_log_check_duration_seconds",
+            Help: "Duration of each log check, including any retries.",
+            Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+        },
+    )
+
+    metricFullScanDuration = prometheus.NewHistogram(
+        prometheus.HistogramOpts{
+            Name: "sayoko_full_scan_duration_seconds",
+            Help: "Duration of each full scan of the registry.",
+            Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+        },
+    )
+
+    metricRequestDuration = prometheus.NewHistogramVec(
+        prometheus.HistogramOpts{
+            Name: "sayoko_sewerrat_request_duration_seconds",
+            Help: "Latency of requests to the SewerRat API, by endpoint.",
+            Buckets: prometheus.DefBuckets,
+        },
+        []string{ "endpoint" },
+    )
+
+    metricLastLogCheck = prometheus.NewGauge(
+        prometheus.GaugeOpts{
+            Name: "sayoko_last_successful_log_check_timestamp_seconds",
+            Help: "Unix time of the start of the last successful log check.",
+        },
+    )
+
+    metricLastFullScan = prometheus.NewGauge(
+        prometheus.GaugeOpts{
+            Name: "sayoko_last_successful_full_scan_timestamp_seconds",
+            Help: "Unix time of the start of the last successful full scan.",
+        },
+    )
+)
+
+// Gobbler log types that are handled by processLogs() and reported individually in the metrics.
+// All other types are reported as 'other', to avoid an unbounded number of label values.
+var knownLogTypes = map[string]bool{
+    "add-version": true,
+    "delete-version": true,
+    "reindex-version": true,
+    "delete-asset": true,
+    "delete-project": true,
+}
+
+func observeLog(log_type string) {
+    if !knownLogTypes[log_type] {
+        log_type = "other"
+    }
+    metricLogsProcessed.WithLabelValues(log_type).Inc()
+}
+
+// Suitable for use as the 'OnAction' hook of a sewerrat.Client.
+func observeAction(action string, dir string, err error) {
+    metricActionsAttempted.WithLabelValues(action).Inc()
+    if err != nil {
+        kind := "transient"
+        if sewerrat.IsPermanent(err) {
+            kind = "permanent"
+        }
+        metricActionsFailed.WithLabelValues(action, kind).Inc()
+    }
+}
+
+// Suitable for use as the 'OnRequest' hook of a sewerrat.Client.
+func observeRequest(endpoint string, status int, latency time.Duration) {
+    metricRequestDuration.WithLabelValues(endpoint).Observe(latency.Seconds())
+}
+
+// Time by which the newest log is ahead of the start of the last successful log check, or zero if all logs were covered by that check.
+// This only uses the timestamps recorded by the log checks, so it is cheap enough to compute upon every scrape.
+func computeLogLag(newest_log time.Time, last_check time.Time) float64 {
+    if !newest_log.After(last_check) {
+        return 0
+    }
+    return newest_log.Sub(last_check).Seconds()
+}
+
+// Creates a registry containing all of sayoko's metrics for the daemon 'd'.
+func newMetricsRegistry(d *daemon) *prometheus.Registry {
+    registry := prometheus.NewRegistry()
+    registry.MustRegister(
+        metricLogsProcessed,
+        metricActionsAttempted,
+        metricActionsFailed,
+        metricLogCheckDuration,
+        metricFullScanDuration,
+        metricRequestDuration,
+        metricLastLogCheck,
+        metricLastFullScan,
+        prometheus.NewGaugeFunc(
+            prometheus.GaugeOpts{
+                Name: "sayoko_log_lag_seconds",
+                Help: "Time by which the newest log is ahead of the start of the last successful log check.",
+            },
+            func() float64 {
+                status := d.getStatus()
+                if status.Logs.LastSucceeded == nil {
+                    return 0
+                }
+                return computeLogLag(status.newest_log, status.Logs.last_succeeded_start)
+            },
+        ),
+        prometheus.NewGaugeFunc(
+            prometheus.GaugeOpts{
+                Name: "sayoko_pending_retries",
+                Help: "Number of projects or assets in the retry queue.",
+            },
+            func() float64 {
+                return float64(d.getStatus().PendingRetries)
+            },
+        ),
+    )
+    return registry
+}
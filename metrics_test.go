@@ -0,0 +1,124 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+func TestObserveMetrics(t *testing.T) {
+    before := testutil.ToFloat64(metricLogsProcessed.WithLabelValues("add-version"))
+    observeLog("add-version")
+    if testutil.ToFloat64(metricLogsProcessed.WithLabelValues("add-version")) != before + 1 {
+        t.Error("expected the log count to increase")
+    }
+
+    before = testutil.ToFloat64(metricLogsProcessed.WithLabelValues("other"))
+    observeLog("whee")
+    if testutil.ToFloat64(metricLogsProcessed.WithLabelValues("other")) != before + 1 {
+        t.Error("expected unknown log types to be reported as 'other'")
+    }
+
+    attempted := testutil.ToFloat64(metricActionsAttempted.WithLabelValues("register"))
+    transient := testutil.ToFloat64(metricActionsFailed.WithLabelValues("register", "transient"))
+    permanent := testutil.ToFloat64(metricActionsFailed.WithLabelValues("register", "permanent"))
+    observeAction("register", "/foo", nil)
+    observeAction("register", "/foo", errors.New("whee"))
+    observeAction("register", "/foo", &sewerrat.Error{ StatusCode: 400, Endpoint: "/register/start", Reason: "whee" })
+    if testutil.ToFloat64(metricActionsAttempted.WithLabelValues("register")) != attempted + 3 {
+        t.Error("expected the attempted count to increase")
+    }
+    if testutil.ToFloat64(metricActionsFailed.WithLabelValues("register", "transient")) != transient + 1 {
+        t.Error("expected the transient failure count to increase")
+    }
+    if testutil.ToFloat64(metricActionsFailed.WithLabelValues("register", "permanent")) != permanent + 1 {
+        t.Error("expected the permanent failure count to increase")
+    }
+
+    observeRequest("/registered", 200, time.Second)
+    if testutil.CollectAndCount(metricRequestDuration) == 0 {
+        t.Error("expected request latencies to be recorded")
+    }
+}
+
+func TestComputeLogLag(t *testing.T) {
+    last_check, err := time.Parse(time.RFC3339, "2022-02-22T02:22:22Z")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if computeLogLag(time.Time{}, last_check) != 0 {
+        t.Error("expected no lag without any logs")
+    }
+    if computeLogLag(last_check.Add(-time.Hour), last_check) != 0 || computeLogLag(last_check, last_check) != 0 {
+        t.Error("expected no lag when all logs are older than the last check")
+    }
+    if computeLogLag(last_check.Add(10 * time.Minute), last_check) != 600 {
+        t.Error("expected a lag of 10 minutes for a newer log")
+    }
+}
+
+func TestMetricsRegistry(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Mkdir(filepath.Join(registry, "..logs"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    ledger, err := openLogLedger(filepath.Join(workdir, "ledger"), time.Now())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ledger.close()
+    retries, err := openRetryQueue(filepath.Join(workdir, "retries"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    d := &daemon{
+        Client: getSewerRatClient(),
+        Registry: registry,
+        Config: newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{}),
+        Ledger: ledger,
+        Retries: retries,
+        Concurrency: 1,
+    }
+
+    err = d.fullScan(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, _, err = d.checkLogs(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if testutil.ToFloat64(metricLastFullScan) == 0 || testutil.ToFloat64(metricLastLogCheck) == 0 {
+        t.Error("expected the scan metrics to be updated")
+    }
+
+    reg := newMetricsRegistry(d)
+    families, err := reg.Gather()
+    if err != nil {
+        t.Fatal(err)
+    }
+    found := map[string]bool{}
+    for _, fam := range families {
+        found[fam.GetName()] = true
+    }
+    for _, name := range []string{ "sayoko_log_lag_seconds", "sayoko_pending_retries", "sayoko_full_scan_duration_seconds", "sayoko_last_successful_log_check_timestamp_seconds" } {
+        if !found[name] {
+            t.Errorf("expected %q in the gathered metrics", name)
+        }
+    }
+}
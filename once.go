@@ -0,0 +1,66 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+// Summary of a one-shot run, to be printed before exiting.
+type onceSummary struct {
+    Mode string
+    Duration time.Duration
+    Logs int
+    Retries int
+    Failures int
+}
+
+func (s onceSummary) String() string {
+    if s.Mode == "log" {
+        return fmt.Sprintf("log check completed in %v with %d failure(s); %d log(s) processed, %d retry(s) pending", s.Duration, s.Failures, s.Logs, s.Retries)
+    }
+    return fmt.Sprintf("full scan completed in %v with %d failure(s)", s.Duration, s.Failures)
+}
+
+func isOnceMode(mode string) bool {
+    return mode == "log" || mode == "full"
+}
+
+// Counts the individual failures in a (possibly nested) joined error.
+func countFailures(err error) int {
+    if err == nil {
+        return 0
+    }
+    if joined, ok := err.(interface{ Unwrap() []error }); ok {
+        total := 0
+        for _, child := range joined.Unwrap() {
+            total += countFailures(child)
+        }
+        return total
+    }
+    return 1
+}
+
+// Performs a single log check (for mode = "log") or full scan (for mode = "full"),
+// for use in cron jobs or other external schedulers where sayoko should not run as a daemon.
+func runOnce(ctx context.Context, mode string, client *sewerrat.Client, registry string, config *indexConfig, ledger *logLedger, retries *retryQueue, concurrency int) (onceSummary, error) {
+    summary := onceSummary{ Mode: mode }
+    start := time.Now()
+
+    var err error
+    if mode == "log" {
+        already_marked := ledger.Marked
+        err = checkLogs(ctx, client, registry, config, ledger, retries)
+        summary.Logs = ledger.Marked - already_marked
+        summary.Retries = len(retries.Entries)
+    } else if mode == "full" {
+        err = fullScan(ctx, client, registry, config, concurrency)
+    } else {
+        return summary, fmt.Errorf("unknown mode %q for a one-shot run", mode)
+    }
+
+    summary.Duration = time.Since(start)
+    summary.Failures = countFailures(err)
+    return summary, err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "testing"
+    "strings"
+    "errors"
+    "time"
+)
+
+func TestCountFailures(t *testing.T) {
+    if countFailures(nil) != 0 {
+        t.Error("expected no failures for a nil error")
+    }
+    if countFailures(errors.New("foo")) != 1 {
+        t.Error("expected one failure for a single error")
+    }
+    nested := errors.Join(errors.New("foo"), errors.Join(errors.New("bar"), errors.New("whee")))
+    if countFailures(nested) != 3 {
+        t.Error("expected three failures for nested errors")
+    }
+    if countFailures(errors.Join(nil, nil)) != 0 {
+        t.Error("expected no failures for an empty join")
+    }
+}
+
+func TestRunOnce(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    err = os.MkdirAll(filepath.Join(registry, "foo", "bar", "1"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(registry, "foo", "bar", "..latest"), []byte("{ \"version\": \"1\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    logdir := filepath.Join(registry, "..logs")
+    err = os.Mkdir(logdir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(logdir, "2022-02-22T02:22:22Z_111111"), []byte("{ \"type\": \"add-version\", \"project\": \"foo\", \"asset\": \"bar\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    cutoff, err := time.Parse(time.RFC3339, "2021-01-21T02:22:22Z")
+    if err != nil {
+        t.Fatal(err)
+    }
+    ledger, err := openLogLedger(filepath.Join(workdir, "ledger"), cutoff)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ledger.close()
+    retries, err := openRetryQueue(filepath.Join(workdir, "retries"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := getSewerRatClient()
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+
+    // Log check processes the new log.
+    {
+        summary, err := runOnce(context.Background(), "log", client, registry, config, ledger, retries, 1)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if summary.Logs != 1 || summary.Failures != 0 || summary.Retries != 0 {
+            t.Errorf("unexpected summary for a log check; %v", summary)
+        }
+        if !strings.Contains(summary.String(), "1 log(s) processed") {
+            t.Errorf("unexpected summary message; %s", summary.String())
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 1 || found[0] != "foo/bar/1" {
+            t.Errorf("unexpected registered directories after a log check; %v", found)
+        }
+
+        // Second check does nothing as the log was already processed.
+        summary, err = runOnce(context.Background(), "log", client, registry, config, ledger, retries, 1)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if summary.Logs != 0 {
+            t.Errorf("expected no logs to be processed in the second check; %v", summary)
+        }
+    }
+
+    // Full scan picks up the removal of an asset.
+    {
+        err := os.RemoveAll(filepath.Join(registry, "foo"))
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        summary, err := runOnce(context.Background(), "full", client, registry, config, ledger, retries, 1)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if summary.Failures != 0 || !strings.Contains(summary.String(), "full scan") {
+            t.Errorf("unexpected summary for a full scan; %v", summary)
+        }
+
+        found, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 0 {
+            t.Errorf("unexpected registered directories after a full scan; %v", found)
+        }
+    }
+
+    // Failures are reported in the summary.
+    {
+        summary, err := runOnce(context.Background(), "full", client, filepath.Join(registry, "missing"), config, ledger, retries, 1)
+        if err == nil || summary.Failures != 1 {
+            t.Errorf("expected a failure for a missing registry; %v", summary)
+        }
+
+        _, err = runOnce(context.Background(), "whee", client, registry, config, ledger, retries, 1)
+        if err == nil || !strings.Contains(err.Error(), "unknown mode") {
+            t.Error("expected an error for an unknown mode")
+        }
+    }
+}
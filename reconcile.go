@@ -0,0 +1,48 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "errors"
+    "fmt"
+    "context"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+// A reconcileTarget is an asset (or, if Asset is empty, a project) whose registrations need to be reconciled with the contents of the registry.
+type reconcileTarget struct {
+    Project string `json:"project"`
+    Asset string `json:"asset,omitempty"`
+    Force bool `json:"force,omitempty"` // whether to forcibly reregister the retained versions.
+}
+
+func (t reconcileTarget) String() string {
+    if t.Asset == "" {
+        return t.Project
+    }
+    return t.Project + "/" + t.Asset
+}
+
+// Reconciles SewerRat's registrations for the target with the current state of the registry.
+// If the target's directory no longer exists, all of its subdirectories are deregistered.
+// Otherwise, for assets, the versions are (de)registered according to the asset's index policy;
+// and for projects, any subdirectories that no longer exist are deregistered.
+func reconcile(ctx context.Context, client *sewerrat.Client, registry string, config *indexConfig, target reconcileTarget) error {
+    target_dir := filepath.Join(registry, target.Project)
+    if target.Asset != "" {
+        target_dir = filepath.Join(target_dir, target.Asset)
+    }
+
+    _, err := os.Stat(target_dir)
+    if err != nil {
+        if !errors.Is(err, os.ErrNotExist) {
+            return fmt.Errorf("failed to inspect %q; %w", target_dir, err)
+        }
+        return client.DeregisterAllSubdirectories(ctx, target_dir)
+    }
+
+    if target.Asset == "" {
+        return client.DeregisterMissingSubdirectories(ctx, target_dir)
+    }
+    return ignoreNonLatest(ctx, client, target_dir, config.lookup(target.Project, target.Asset), target.Force)
+}
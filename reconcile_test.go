@@ -0,0 +1,84 @@
+package main
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestReconcile(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    client := getSewerRatClient()
+    names := []string{ "metadata.json" }
+    config := newIndexConfig(names, latestOnlyRetention{})
+
+    for _, asset := range []string{ "bar", "stuff" } {
+        for _, version := range []string{ "1", "2" } {
+            version_dir := filepath.Join(registry, "foo", asset, version)
+            err := os.MkdirAll(version_dir, 0755)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = client.RegisterDirectory(context.Background(), version_dir, names)
+            if err != nil {
+                t.Fatal(err)
+            }
+        }
+        err = os.WriteFile(filepath.Join(registry, "foo", asset, "..latest"), []byte("{ \"version\": \"2\" }"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // Existing asset is reconciled according to its policy.
+    err = reconcile(context.Background(), client, registry, config, reconcileTarget{ Project: "foo", Asset: "bar" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    found, err := client.ListRegisteredSubdirectories(context.Background(), filepath.Join(registry, "foo", "bar"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0] != "2" {
+        t.Errorf("expected only the latest version to be registered; %v", found)
+    }
+
+    // Existing project only has its missing subdirectories deregistered.
+    err = os.RemoveAll(filepath.Join(registry, "foo", "stuff", "1"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = reconcile(context.Background(), client, registry, config, reconcileTarget{ Project: "foo" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    found, err = client.ListRegisteredSubdirectories(context.Background(), registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 2 {
+        t.Errorf("expected only missing directories to be deregistered; %v", found)
+    }
+
+    // Missing asset is deregistered entirely.
+    err = os.RemoveAll(filepath.Join(registry, "foo", "bar"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = reconcile(context.Background(), client, registry, config, reconcileTarget{ Project: "foo", Asset: "bar" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    found, err = client.ListRegisteredSubdirectories(context.Background(), registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0] != "foo/stuff/2" {
+        t.Errorf("expected the missing asset to be deregistered; %v", found)
+    }
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "strconv"
+    "sort"
+    "fmt"
+    "errors"
+    "time"
+)
+
+// A retentionPolicy decides which versions of an asset should be registered with SewerRat.
+// All other versions of the asset are deregistered.
+type retentionPolicy interface {
+    // Versions to be registered for the asset at 'asset_dir', given the version listed in its '..latest' file.
+    // 'latest' may be empty if the asset has no '..latest' file.
+    retainedVersions(asset_dir string, latest string) ([]string, error)
+}
+
+type latestOnlyRetention struct {}
+
+func (p latestOnlyRetention) retainedVersions(asset_dir string, latest string) ([]string, error) {
+    if latest == "" {
+        return []string{}, nil
+    }
+    return []string{ latest }, nil
+}
+
+type latestCountRetention struct {
+    Count int
+}
+
+func (p latestCountRetention) retainedVersions(asset_dir string, latest string) ([]string, error) {
+    if latest == "" {
+        return []string{}, nil
+    }
+
+    completed, err := listCompletedVersions(asset_dir)
+    if err != nil {
+        return nil, err
+    }
+
+    // The latest version is always retained, even if it is older than the others.
+    output := []string{ latest }
+    for _, ver := range completed {
+        if len(output) >= p.Count {
+            break
+        }
+        if ver != latest {
+            output = append(output, ver)
+        }
+    }
+    return output, nil
+}
+
+type allVersionsRetention struct {}
+
+func (p allVersionsRetention) retainedVersions(asset_dir string, latest string) ([]string, error) {
+    completed, err := listCompletedVersions(asset_dir)
+    if err != nil {
+        return nil, err
+    }
+
+    output := []string{}
+    if latest != "" {
+        output = append(output, latest)
+    }
+    for _, ver := range completed {
+        if ver != latest {
+            output = append(output, ver)
+        }
+    }
+    return output, nil
+}
+
+type allowListRetention struct {
+    Versions []string
+}
+
+func (p allowListRetention) retainedVersions(asset_dir string, latest string) ([]string, error) {
+    output := []string{}
+    for _, ver := range p.Versions {
+        info, err := os.Stat(filepath.Join(asset_dir, ver))
+        if err == nil && info.IsDir() {
+            output = append(output, ver)
+        } else if err != nil && !errors.Is(err, os.ErrNotExist) {
+            return nil, fmt.Errorf("failed to inspect version %q of %q; %w", ver, asset_dir, err)
+        }
+    }
+    return output, nil
+}
+
+// Lists all versions of an asset that have a '..summary' file, i.e., their upload has finished.
+// Probational versions are ignored.
+// Versions are sorted by decreasing upload finish time, with ties broken by the version name.
+func listCompletedVersions(asset_dir string) ([]string, error) {
+    contents, err := os.ReadDir(asset_dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list versions of %q; %w", asset_dir, err)
+    }
+
+    type versionFinish struct {
+        Version string
+        Finish time.Time
+    }
+    collected := []versionFinish{}
+
+    for _, entry := range contents {
+        if !entry.IsDir() || strings.HasPrefix(entry.Name(), "..") {
+            continue
+        }
+        version := entry.Name()
+        summary, err := readSummaryFile(filepath.Join(asset_dir, version, "..summary"))
+        if err != nil {
+            if errors.Is(err, os.ErrNotExist) {
+                continue
+            }
+            return nil, err
+        }
+        if summary.OnProbation {
+            continue
+        }
+
+        finish, err := time.Parse(time.RFC3339, summary.UploadFinish)
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse upload finish time for %q; %w", filepath.Join(asset_dir, version), err)
+        }
+        collected = append(collected, versionFinish{ Version: version, Finish: finish })
+    }
+
+    sort.Slice(collected, func(i, j int) bool {
+        if collected[i].Finish.Equal(collected[j].Finish) {
+            return collected[i].Version > collected[j].Version
+        }
+        return collected[i].Finish.After(collected[j].Finish)
+    })
+
+    output := make([]string, len(collected))
+    for i, entry := range collected {
+        output[i] = entry.Version
+    }
+    return output, nil
+}
+
+// Parses a retention policy from its command-line representation, which should be one of:
+//
+// - "latest", to retain only the latest version.
+// - "latest:N", to retain the latest version and the N-1 most recently uploaded versions.
+// - "all", to retain all versions.
+// - "versions:A,B,C", to retain only the named versions.
+func parseRetentionPolicy(spec string) (retentionPolicy, error) {
+    if spec == "latest" {
+        return latestOnlyRetention{}, nil
+    }
+    if spec == "all" {
+        return allVersionsRetention{}, nil
+    }
+
+    if strings.HasPrefix(spec, "latest:") {
+        count, err := strconv.Atoi(strings.TrimPrefix(spec, "latest:"))
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse the number of versions in retention policy %q; %w", spec, err)
+        }
+        if count < 1 {
+            return nil, fmt.Errorf("number of versions in retention policy %q should be positive", spec)
+        }
+        if count == 1 {
+            return latestOnlyRetention{}, nil
+        }
+        return latestCountRetention{ Count: count }, nil
+    }
+
+    if strings.HasPrefix(spec, "versions:") {
+        versions := []string{}
+        for _, ver := range strings.Split(strings.TrimPrefix(spec, "versions:"), ",") {
+            if ver != "" {
+                versions = append(versions, ver)
+            }
+        }
+        if len(versions) == 0 {
+            return nil, fmt.Errorf("no versions listed in retention policy %q", spec)
+        }
+        return allowListRetention{ Versions: versions }, nil
+    }
+
+    return nil, fmt.Errorf("unknown retention policy %q", spec)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "strings"
+)
+
+func mockVersionsWithSummaries(asset_dir string, versions map[string]string) error {
+    for ver, finish := range versions {
+        version_dir := filepath.Join(asset_dir, ver)
+        err := os.MkdirAll(version_dir, 0755)
+        if err != nil {
+            return err
+        }
+        err = os.WriteFile(filepath.Join(version_dir, "..summary"), []byte("{ \"upload_finish\": \"" + finish + "\" }"), 0644)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func TestListCompletedVersions(t *testing.T) {
+    asset_dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = mockVersionsWithSummaries(asset_dir, map[string]string{
+        "alpha": "2021-01-21T02:22:22Z",
+        "gamma": "2023-03-23T03:33:33Z",
+        "beta": "2022-02-22T02:22:22Z",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Incomplete versions without a summary are ignored.
+    err = os.Mkdir(filepath.Join(asset_dir, "delta"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    found, err := listCompletedVersions(asset_dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 3 || found[0] != "gamma" || found[1] != "beta" || found[2] != "alpha" {
+        t.Errorf("unexpected ordering of completed versions; %v", found)
+    }
+}
+
+func TestRetainedVersions(t *testing.T) {
+    asset_dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = mockVersionsWithSummaries(asset_dir, map[string]string{
+        "1": "2021-01-21T02:22:22Z",
+        "2": "2022-02-22T02:22:22Z",
+        "3": "2023-03-23T03:33:33Z",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("latest only", func(t *testing.T) {
+        found, err := latestOnlyRetention{}.retainedVersions(asset_dir, "2")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 1 || found[0] != "2" {
+            t.Errorf("unexpected retained versions; %v", found)
+        }
+
+        found, err = latestOnlyRetention{}.retainedVersions(asset_dir, "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 0 {
+            t.Errorf("expected no retained versions without a latest version; %v", found)
+        }
+    })
+
+    t.Run("latest count", func(t *testing.T) {
+        found, err := latestCountRetention{ Count: 2 }.retainedVersions(asset_dir, "3")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 2 || found[0] != "3" || found[1] != "2" {
+            t.Errorf("unexpected retained versions; %v", found)
+        }
+
+        // Latest version is always retained.
+        found, err = latestCountRetention{ Count: 2 }.retainedVersions(asset_dir, "1")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 2 || found[0] != "1" || found[1] != "3" {
+            t.Errorf("unexpected retained versions; %v", found)
+        }
+
+        found, err = latestCountRetention{ Count: 10 }.retainedVersions(asset_dir, "3")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 3 {
+            t.Errorf("unexpected retained versions; %v", found)
+        }
+    })
+
+    t.Run("all", func(t *testing.T) {
+        found, err := allVersionsRetention{}.retainedVersions(asset_dir, "2")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 3 || found[0] != "2" || found[1] != "3" || found[2] != "1" {
+            t.Errorf("unexpected retained versions; %v", found)
+        }
+    })
+
+    t.Run("allow list", func(t *testing.T) {
+        found, err := allowListRetention{ Versions: []string{ "1", "3", "4" } }.retainedVersions(asset_dir, "2")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 2 || found[0] != "1" || found[1] != "3" {
+            t.Errorf("unexpected retained versions; %v", found)
+        }
+    })
+}
+
+func TestParseRetentionPolicy(t *testing.T) {
+    policy, err := parseRetentionPolicy("latest")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := policy.(latestOnlyRetention); !ok {
+        t.Errorf("expected a latest-only policy")
+    }
+
+    policy, err = parseRetentionPolicy("latest:5")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if casted, ok := policy.(latestCountRetention); !ok || casted.Count != 5 {
+        t.Errorf("expected a latest-N policy")
+    }
+
+    policy, err = parseRetentionPolicy("all")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := policy.(allVersionsRetention); !ok {
+        t.Errorf("expected an all-versions policy")
+    }
+
+    policy, err = parseRetentionPolicy("versions:foo,bar")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if casted, ok := policy.(allowListRetention); !ok || len(casted.Versions) != 2 || casted.Versions[0] != "foo" || casted.Versions[1] != "bar" {
+        t.Errorf("expected an allow-list policy")
+    }
+
+    _, err = parseRetentionPolicy("latest:0")
+    if err == nil || !strings.Contains(err.Error(), "positive") {
+        t.Error("expected an error for a non-positive count")
+    }
+
+    _, err = parseRetentionPolicy("versions:")
+    if err == nil || !strings.Contains(err.Error(), "no versions") {
+        t.Error("expected an error for an empty allow-list")
+    }
+
+    _, err = parseRetentionPolicy("whee")
+    if err == nil || !strings.Contains(err.Error(), "unknown") {
+        t.Error("expected an error for an unknown policy")
+    }
+}
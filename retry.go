@@ -0,0 +1,225 @@
+package main
+
+import (
+    "os"
+    "time"
+    "sort"
+    "math/rand"
+    "encoding/json"
+    "errors"
+    "io/fs"
+    "fmt"
+    "context"
+    "log/slog"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+const retryBaseDelay = 30 * time.Second
+const retryMaxDelay = 30 * time.Minute
+
+// Maximum number of failed attempts before a target is dropped from the queue.
+// With the maximum delay, this corresponds to roughly a day of retries, which should be enough to outlast any transient SewerRat outage.
+const retryMaxAttempts = 50
+
+// Delay before the next attempt, after the specified number of failed attempts.
+// This increases exponentially with the number of attempts, up to a maximum of 'retryMaxDelay'.
+// Some jitter is added to avoid hammering SewerRat with many retries at once after an outage.
+func computeRetryDelay(attempts int) time.Duration {
+    delay := retryBaseDelay
+    for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+        delay *= 2
+    }
+    if delay > retryMaxDelay {
+        delay = retryMaxDelay
+    }
+    half := delay / 2
+    return half + time.Duration(rand.Int63n(int64(half) + 1))
+}
+
+type retryEntry struct {
+    Target reconcileTarget `json:"target"`
+    Attempts int `json:"attempts"`
+    Next time.Time `json:"next"`
+}
+
+// A retryQueue contains the targets whose reconciliation failed and should be retried later.
+// This is persisted to a JSON file so that retries are not lost when sayoko is restarted.
+type retryQueue struct {
+    Path string
+    Entries map[string]*retryEntry
+}
+
+func openRetryQueue(queue_path string) (*retryQueue, error) {
+    output := &retryQueue{
+        Path: queue_path,
+        Entries: map[string]*retryEntry{},
+    }
+
+    contents, err := os.ReadFile(queue_path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return output, nil
+        }
+        return nil, fmt.Errorf("failed to read %q; %w", queue_path, err)
+    }
+
+    entries := []retryEntry{}
+    err = json.Unmarshal(contents, &entries)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse %q; %w", queue_path, err)
+    }
+    for _, entry := range entries {
+        copied := entry
+        output.Entries[entry.Target.String()] = &copied
+    }
+
+    return output, nil
+}
+
+func (q *retryQueue) save() error {
+    entries := []*retryEntry{}
+    for _, entry := range q.Entries {
+        entries = append(entries, entry)
+    }
+    sort.Slice(entries, func(i, j int) bool {
+        return entries[i].Target.String() < entries[j].Target.String()
+    })
+
+    contents, err := json.Marshal(entries)
+    if err != nil {
+        return fmt.Errorf("failed to serialize the retry queue; %w", err)
+    }
+
+    // Writing to a temporary file and then moving it over, to avoid corrupting the queue upon interruption.
+    temp_path := q.Path + ".tmp"
+    err = os.WriteFile(temp_path, contents, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to write a temporary retry queue at %q; %w", temp_path, err)
+    }
+    err = os.Rename(temp_path, q.Path)
+    if err != nil {
+        return fmt.Errorf("failed to replace the retry queue at %q; %w", q.Path, err)
+    }
+    return nil
+}
+
+func (q *retryQueue) schedule(target reconcileTarget, now time.Time) {
+    key := target.String()
+    entry, ok := q.Entries[key]
+    if !ok {
+        entry = &retryEntry{ Target: target }
+        q.Entries[key] = entry
+    } else if target.Force {
+        entry.Target.Force = true
+    }
+    entry.Attempts++
+    entry.Next = now.Add(computeRetryDelay(entry.Attempts))
+}
+
+// Adds a target to the queue after a failed reconciliation.
+// If the target is already in the queue, its next attempt is delayed further.
+func (q *retryQueue) push(target reconcileTarget) error {
+    q.schedule(target, time.Now())
+    return q.save()
+}
+
+// Time of the next retry, or false if the queue is empty.
+func (q *retryQueue) nextDue() (time.Time, bool) {
+    output := time.Time{}
+    found := false
+    for _, entry := range q.Entries {
+        if !found || entry.Next.Before(output) {
+            output = entry.Next
+            found = true
+        }
+    }
+    return output, found
+}
+
+// Retries the reconciliation of all targets in the queue that are due at 'now'.
+// Successfully reconciled targets are removed from the queue, while failed targets are rescheduled.
+// Targets are dropped from the queue if the failure is permanent or if they have already been attempted 'retryMaxAttempts' times.
+func processRetries(ctx context.Context, client *sewerrat.Client, registry string, config *indexConfig, retries *retryQueue, now time.Time) error {
+    due := []reconcileTarget{}
+    for _, entry := range retries.Entries {
+        if !entry.Next.After(now) {
+            due = append(due, entry.Target)
+        }
+    }
+    if len(due) == 0 {
+        return nil
+    }
+
+    all_errors := []error{}
+    for _, target := range due {
+        err := reconcile(ctx, client, registry, config, target)
+        if ctx.Err() != nil {
+            // Leaving the remaining targets in the queue so that they are retried upon restart.
+            all_errors = append(all_errors, fmt.Errorf("retries were cancelled; %w", ctx.Err()))
+            break
+        }
+        logger := slog.With("project", target.Project, "asset", target.Asset, "attempts", retries.Entries[target.String()].Attempts)
+        if err != nil {
+            all_errors = append(all_errors, fmt.Errorf("failed to retry reconciliation for %q; %w", target.String(), err))
+            if !isRetryable(err) {
+                logger.Error("permanently failed to retry reconciliation; dropping from the queue", "error", err)
+                delete(retries.Entries, target.String())
+            } else if retries.Entries[target.String()].Attempts >= retryMaxAttempts {
+                logger.Error("exceeded the maximum number of retries; dropping from the queue", "error", err)
+                delete(retries.Entries, target.String())
+            } else {
+                logger.Error("failed to retry reconciliation", "error", err)
+                retries.schedule(target, now)
+            }
+        } else {
+            logger.Info("successfully retried reconciliation")
+            delete(retries.Entries, target.String())
+        }
+    }
+
+    err := retries.save()
+    if err != nil {
+        all_errors = append(all_errors, err)
+    }
+
+    if len(all_errors) > 0 {
+        return errors.Join(all_errors...)
+    } else {
+        return nil
+    }
+}
+
+// Whether a failed reconciliation should be retried, i.e., at least one of the (possibly joined) errors in 'err' is temporary.
+// Temporary errors are those where SewerRat is unreachable or responds with a 5xx, 408 or 429 status code,
+// or where a directory in the registry is missing or cannot be accessed, e.g., due to lag or sporadic unmounting of a shared filesystem.
+// SewerRat also rejects directories that do not exist, but these may just be lagging, so they are still retried.
+// All other errors (e.g., malformed '..latest' or '..summary' files) will not be fixed by retrying and are considered to be permanent.
+func isRetryable(err error) bool {
+    if err == nil {
+        return false
+    }
+    if joined, ok := err.(interface{ Unwrap() []error }); ok {
+        for _, child := range joined.Unwrap() {
+            if isRetryable(child) {
+                return true
+            }
+        }
+        return false
+    }
+
+    var serr *sewerrat.Error
+    if errors.As(err, &serr) {
+        if !serr.Permanent() {
+            return true
+        }
+        if serr.Path != "" {
+            if _, err := os.Stat(serr.Path); err != nil {
+                return true
+            }
+        }
+        return false
+    }
+
+    var perr *fs.PathError
+    return errors.As(err, &perr)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    "strings"
+    "errors"
+    "fmt"
+    "io/fs"
+    "encoding/json"
+    "github.com/ArtifactDB/sayoko/sewerrat"
+)
+
+func TestComputeRetryDelay(t *testing.T) {
+    for attempts, expected := range map[int]time.Duration{
+        1: retryBaseDelay,
+        2: retryBaseDelay * 2,
+        3: retryBaseDelay * 4,
+        100: retryMaxDelay,
+    } {
+        for i := 0; i < 10; i++ {
+            delay := computeRetryDelay(attempts)
+            if delay < expected / 2 || delay > expected {
+                t.Errorf("delay %v for %d attempts should lie in [%v, %v]", delay, attempts, expected / 2, expected)
+            }
+        }
+    }
+}
+
+func TestRetryQueue(t *testing.T) {
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create working directory; %v", err)
+    }
+    queue_path := filepath.Join(workdir, "retries")
+
+    retries, err := openRetryQueue(queue_path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, found := retries.nextDue(); found {
+        t.Error("expected no retries in a new queue")
+    }
+
+    before := time.Now()
+    err = retries.push(reconcileTarget{ Project: "foo", Asset: "bar" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = retries.push(reconcileTarget{ Project: "whee" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = retries.push(reconcileTarget{ Project: "foo", Asset: "bar", Force: true })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    entry, found := retries.Entries["foo/bar"]
+    if !found || entry.Attempts != 2 || !entry.Target.Force {
+        t.Errorf("unexpected entry after multiple pushes; %v", entry)
+    }
+    next, found := retries.nextDue()
+    if !found || next.Before(before.Add(retryBaseDelay / 2)) {
+        t.Errorf("unexpected time for the next retry; %v", next)
+    }
+
+    // Reloading the queue from disk.
+    reloaded, err := openRetryQueue(queue_path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(reloaded.Entries) != 2 {
+        t.Fatalf("unexpected entries after reloading; %v", reloaded.Entries)
+    }
+    entry, found = reloaded.Entries["foo/bar"]
+    if !found || entry.Attempts != 2 || !entry.Target.Force || entry.Target.Project != "foo" || entry.Target.Asset != "bar" {
+        t.Errorf("unexpected entry after reloading; %v", entry)
+    }
+    entry, found = reloaded.Entries["whee"]
+    if !found || entry.Attempts != 1 || entry.Target.Project != "whee" || entry.Target.Asset != "" {
+        t.Errorf("unexpected entry after reloading; %v", entry)
+    }
+
+    // Checking for failures.
+    err = os.WriteFile(queue_path, []byte("foobar"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = openRetryQueue(queue_path)
+    if err == nil || !strings.Contains(err.Error(), "failed to parse") {
+        t.Error("expected an error for an invalid queue")
+    }
+}
+
+func TestProcessRetries(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create registry; %v", err)
+    }
+
+    asset_dir := filepath.Join(registry, "foo", "bar")
+    err = os.MkdirAll(asset_dir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(asset_dir, "..latest"), []byte("{ \"version\": \"1\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    retries, err := openRetryQueue(filepath.Join(registry, "retries"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = retries.push(reconcileTarget{ Project: "foo", Asset: "bar", Force: true })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := getSewerRatClient()
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+
+    // Nothing happens if the retry isn't due yet.
+    err = processRetries(context.Background(), client, registry, config, retries, time.Now())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if retries.Entries["foo/bar"].Attempts != 1 {
+        t.Error("retry should not have been attempted before it is due")
+    }
+
+    // Forced reregistration fails as the version directory doesn't exist, so it gets rescheduled.
+    now := time.Now().Add(time.Hour)
+    err = processRetries(context.Background(), client, registry, config, retries, now)
+    if err == nil || !strings.Contains(err.Error(), "foo/bar") {
+        t.Error("expected a failure from retrying a missing version")
+    }
+    entry, found := retries.Entries["foo/bar"]
+    if !found || entry.Attempts != 2 || !entry.Next.After(now) {
+        t.Errorf("expected the failed retry to be rescheduled; %v", entry)
+    }
+
+    // Now it succeeds and gets removed from the queue.
+    err = os.Mkdir(filepath.Join(asset_dir, "1"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = processRetries(context.Background(), client, registry, config, retries, now.Add(time.Hour))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(retries.Entries) != 0 {
+        t.Errorf("expected the successful retry to be removed from the queue; %v", retries.Entries)
+    }
+
+    found_dirs, err := client.ListRegisteredSubdirectories(context.Background(), registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found_dirs) != 1 || found_dirs[0] != "foo/bar/1" {
+        t.Errorf("expected 'foo/bar/1' to be registered after the retry; %v", found_dirs)
+    }
+
+    reloaded, err := openRetryQueue(filepath.Join(registry, "retries"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(reloaded.Entries) != 0 {
+        t.Errorf("expected the saved queue to be empty; %v", reloaded.Entries)
+    }
+
+    // A malformed '..latest' file will not be fixed by retrying, so the target is dropped.
+    broken_dir := filepath.Join(registry, "foo", "broken")
+    err = os.MkdirAll(filepath.Join(broken_dir, "1"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(broken_dir, "..latest"), []byte("{ \"version\": "), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = retries.push(reconcileTarget{ Project: "foo", Asset: "broken" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = processRetries(context.Background(), client, registry, config, retries, now.Add(2 * time.Hour))
+    if err == nil || !strings.Contains(err.Error(), "foo/broken") {
+        t.Error("expected a failure from retrying an asset with a malformed '..latest' file")
+    }
+    if len(retries.Entries) != 0 {
+        t.Errorf("expected the permanently failed retry to be dropped; %v", retries.Entries)
+    }
+
+    // Transient failures are dropped after the maximum number of attempts.
+    retries.Entries["foo/lagging"] = &retryEntry{ Target: reconcileTarget{ Project: "foo", Asset: "lagging", Force: true }, Attempts: retryMaxAttempts - 1 }
+    err = os.MkdirAll(filepath.Join(registry, "foo", "lagging"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(registry, "foo", "lagging", "..latest"), []byte("{ \"version\": \"1\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = processRetries(context.Background(), client, registry, config, retries, now.Add(2 * time.Hour))
+    if err == nil {
+        t.Error("expected a failure from retrying a missing version")
+    }
+    entry, found = retries.Entries["foo/lagging"]
+    if !found || entry.Attempts != retryMaxAttempts {
+        t.Errorf("expected the failed retry to be rescheduled before reaching the maximum; %v", entry)
+    }
+    err = processRetries(context.Background(), client, registry, config, retries, entry.Next)
+    if err == nil {
+        t.Error("expected a failure from retrying a missing version")
+    }
+    if len(retries.Entries) != 0 {
+        t.Errorf("expected the retry to be dropped after the maximum number of attempts; %v", retries.Entries)
+    }
+}
+
+func TestIsRetryable(t *testing.T) {
+    permanent := &sewerrat.Error{ StatusCode: 400, Endpoint: "/register/start", Reason: "whee" }
+    transient := &sewerrat.Error{ StatusCode: 503, Endpoint: "/register/start", Reason: "whee" }
+
+    if isRetryable(nil) {
+        t.Error("expected no retry without an error")
+    }
+    if isRetryable(permanent) || isRetryable(fmt.Errorf("failed; %w", permanent)) {
+        t.Error("expected no retry for a permanent failure")
+    }
+    if !isRetryable(transient) || !isRetryable(fmt.Errorf("failed; %w", &fs.PathError{ Op: "open", Path: "/foo", Err: fs.ErrNotExist })) {
+        t.Error("expected a retry for transient failures")
+    }
+    if isRetryable(errors.New("whee")) || isRetryable(fmt.Errorf("failed to parse; %w", &json.SyntaxError{})) {
+        t.Error("expected no retry for local failures that are not related to the filesystem")
+    }
+    if isRetryable(errors.Join(permanent, fmt.Errorf("failed; %w", permanent))) {
+        t.Error("expected no retry when all failures are permanent")
+    }
+    if !isRetryable(errors.Join(permanent, transient)) {
+        t.Error("expected a retry when any failure is transient")
+    }
+
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if isRetryable(&sewerrat.Error{ StatusCode: 400, Endpoint: "/register/start", Path: dir, Reason: "whee" }) {
+        t.Error("expected no retry for a permanent failure on an existing directory")
+    }
+    if !isRetryable(&sewerrat.Error{ StatusCode: 400, Endpoint: "/register/start", Path: filepath.Join(dir, "missing"), Reason: "whee" }) {
+        t.Error("expected a retry for a rejected directory that does not exist yet")
+    }
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+    "github.com/ArtifactDB/sayoko/gobblertest"
+)
+
+func TestEndToEndScenario(t *testing.T) {
+    registry, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    reg, err := gobblertest.Create(registry, gobblertest.GenerateSpec(2, 2, 2))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    workdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    ledger, err := openLogLedger(filepath.Join(workdir, "ledger"), time.Time{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ledger.close()
+    retries, err := openRetryQueue(filepath.Join(workdir, "retries"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := getSewerRatClient()
+    config := newIndexConfig([]string{ "metadata.json" }, latestOnlyRetention{})
+    ctx := context.Background()
+    defer client.DeregisterAllSubdirectories(ctx, registry) // to avoid affecting other tests.
+
+    listRegistered := func() string {
+        found, err := client.ListRegisteredSubdirectories(ctx, registry)
+        if err != nil {
+            t.Fatal(err)
+        }
+        sort.Strings(found)
+        return strings.Join(found, ",")
+    }
+
+    err = fullScan(ctx, client, registry, config, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if found := listRegistered(); found != "project-0/asset-0/2,project-0/asset-1/2,project-1/asset-0/2,project-1/asset-1/2" {
+        t.Errorf("unexpected registrations after the initial scan; %v", found)
+    }
+
+    // Applying a series of Gobbler operations.
+    finish := time.Now().UTC()
+    mutations := []func() (string, error){
+        func() (string, error) { return reg.AddVersion("project-0", "asset-0", gobblertest.Version{ Name: "3", Finish: finish }) },
+        func() (string, error) { return reg.AddVersion("project-0", "asset-1", gobblertest.Version{ Name: "3", Finish: finish, Probation: true }) },
+        func() (string, error) { return reg.DeleteAsset("project-1", "asset-0") },
+        func() (string, error) { return reg.DeleteVersion("project-1", "asset-1", "2") },
+    }
+    for _, mut := range mutations {
+        _, err := mut()
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    err = checkLogs(ctx, client, registry, config, ledger, retries)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := "project-0/asset-0/3,project-0/asset-1/2,project-1/asset-1/1"
+    if found := listRegistered(); found != expected {
+        t.Errorf("unexpected registrations after processing the logs; %v", found)
+    }
+
+    // A subsequent full scan should not change anything.
+    err = fullScan(ctx, client, registry, config, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if found := listRegistered(); found != expected {
+        t.Errorf("unexpected registrations after the final scan; %v", found)
+    }
+
+    // Approving the probational version.
+    _, err = reg.ApproveProbation("project-0", "asset-1", "3")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = checkLogs(ctx, client, registry, config, ledger, retries)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if found := listRegistered(); found != "project-0/asset-0/3,project-0/asset-1/3,project-1/asset-1/1" {
+        t.Errorf("unexpected registrations after approving probation; %v", found)
+    }
+}
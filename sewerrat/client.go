@@ -0,0 +1,422 @@
+// Package sewerrat provides a client for the SewerRat REST API,
+// focusing on the registration and deregistration of directories.
+package sewerrat
+
+import (
+    "io"
+    "context"
+    "errors"
+    "net/http"
+    "net/url"
+    "path/filepath"
+    "encoding/json"
+    "fmt"
+    "bytes"
+    "os"
+    "time"
+    "log/slog"
+    "mime"
+    "strings"
+)
+
+// Default timeout for each request to the SewerRat API.
+// This is fairly generous as SewerRat indexes the directory contents before responding to a registration request.
+const DefaultTimeout = 10 * time.Minute
+
+// Default user agent for requests to the SewerRat API.
+const DefaultUserAgent = "sayoko"
+
+// Client for the SewerRat REST API.
+type Client struct {
+    // URL of the SewerRat API.
+    URL string
+
+    // HTTP client used to perform requests.
+    // This can be modified to customize the transport or timeouts.
+    HTTPClient *http.Client
+
+    // User agent to report in each request.
+    UserAgent string
+
+    // If not nil, the client operates in dry-run mode.
+    // Registrations and deregistrations are recorded in this plan instead of being sent to SewerRat,
+    // while queries for the registered directories are still performed against the live SewerRat state.
+    DryRun *Plan
+
+    // If not nil, this is called after each request to the SewerRat API with the endpoint (e.g., "/register/start"),
+    // the HTTP status code (or zero if no response was received) and the latency of the request.
+    OnRequest func(endpoint string, status int, latency time.Duration)
+
+    // If not nil, this is called after each attempt to register, reindex or deregister a directory,
+    // with the type of action (i.e., 'ActionRegister', 'ActionReindex' or 'ActionDeregister'), the path to the directory and the error, if any.
+    // This is not called in dry-run mode.
+    OnAction func(action string, dir string, err error)
+
+    // If not nil, this is used to log each request at the debug level, along with any failures at the warning level.
+    Logger *slog.Logger
+}
+
+func (c *Client) log(level slog.Level, msg string, args ...any) {
+    if c.Logger != nil {
+        c.Logger.Log(context.Background(), level, msg, args...)
+    }
+}
+
+// Creates a new client for the SewerRat API at 'rest_url', using an HTTP client with a timeout of 'DefaultTimeout'.
+func NewClient(rest_url string) *Client {
+    return &Client{
+        URL: rest_url,
+        HTTPClient: &http.Client{ Timeout: DefaultTimeout },
+        UserAgent: DefaultUserAgent,
+    }
+}
+
+func (c *Client) do(req *http.Request, endpoint string, dir string) (*http.Response, error) {
+    if c.UserAgent != "" {
+        req.Header.Set("User-Agent", c.UserAgent)
+    }
+    client := c.HTTPClient
+    if client == nil {
+        client = http.DefaultClient
+    }
+
+    start := time.Now()
+    resp, err := client.Do(req)
+    latency := time.Since(start)
+    status := 0
+    if err == nil {
+        status = resp.StatusCode
+    }
+    if c.OnRequest != nil {
+        c.OnRequest(endpoint, status, latency)
+    }
+    if err != nil {
+        c.log(slog.LevelWarn, "failed to reach SewerRat", "endpoint", endpoint, "path", dir, "error", err)
+        return nil, &Error{ Endpoint: endpoint, Path: dir, Reason: err.Error(), Err: err }
+    }
+    c.log(slog.LevelDebug, "SewerRat request", "endpoint", endpoint, "path", dir, "status", status, "latency", latency)
+    return resp, nil
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, dir string, target string) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+    if err != nil {
+        return nil, err
+    }
+    return c.do(req, endpoint, dir)
+}
+
+func (c *Client) postJson(ctx context.Context, endpoint string, dir string, payload interface{}) (*http.Response, error) {
+    b, err := json.Marshal(payload)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create request body; %w", err)
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL + endpoint, bytes.NewReader(b))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    return c.do(req, endpoint, dir)
+}
+
+type errorResponse struct {
+    Reason *string `json:"reason"`
+}
+
+// Converts an error response from the 'endpoint' for directory 'dir' into an *Error.
+func parseFailure(resp *http.Response, endpoint string, dir string) *Error {
+    output := &Error{ StatusCode: resp.StatusCode, Endpoint: endpoint, Path: dir }
+
+    ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+    if err != nil {
+        output.Reason = "failed to parse the content type of the error response"
+        output.Err = err
+        return output
+    }
+
+    if ct == "application/json" {
+        dec := json.NewDecoder(resp.Body)
+        errinfo := errorResponse{}
+        err := dec.Decode(&errinfo)
+        if err != nil {
+            output.Reason = "failed to parse the error response"
+            output.Err = err
+        } else if errinfo.Reason == nil {
+            output.Reason = "lack of 'reason' in the error response"
+        } else {
+            output.Reason = *(errinfo.Reason)
+        }
+        return output
+    }
+
+    if ct == "text/plain" {
+        b, err := io.ReadAll(resp.Body)
+        if err != nil {
+            output.Reason = "failed to read the error response"
+            output.Err = err
+        } else {
+            output.Reason = strings.TrimSpace(string(b))
+        }
+        return output
+    }
+
+    output.Reason = fmt.Sprintf("unknown content type %q for the error response", ct)
+    return output
+}
+
+// Checks whether the SewerRat API is reachable, i.e., it responds to a request without a server error.
+func (c *Client) Ping(ctx context.Context) error {
+    resp, err := c.get(ctx, "/", "", c.URL + "/")
+    if err != nil {
+        return fmt.Errorf("failed to reach the SewerRat API; %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 500 {
+        return fmt.Errorf("server error from the SewerRat API (%d)", resp.StatusCode)
+    }
+    return nil
+}
+
+// A RegisteredDirectory is a directory that is registered with SewerRat.
+type RegisteredDirectory struct {
+    Path string `json:"path"`
+}
+
+// Options for ListRegisteredDirectories.
+type ListOptions struct {
+    // If not empty, only directories within this path are listed.
+    WithinPath string
+
+    // If not nil, only directories that do (or do not) exist are listed.
+    Exists *bool
+}
+
+func (c *Client) listRegisteredDirectoriesRaw(ctx context.Context, dir string, target string) ([]RegisteredDirectory, error) {
+    base, err := url.Parse(c.URL)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse the SewerRat URL; %w", err)
+    }
+
+    output := []RegisteredDirectory{}
+    for target != "" {
+        err := func() error { // wrap in a function so that body is closed in a timely fashion.
+            resp, err := c.get(ctx, "/registered", dir, target)
+            if err != nil {
+                return err
+            }
+            defer resp.Body.Close()
+
+            if resp.StatusCode != 200 {
+                err := parseFailure(resp, "/registered", dir)
+                c.log(slog.LevelWarn, "failed to list registered directories", "endpoint", "/registered", "path", dir, "status", resp.StatusCode, "error", err.Reason)
+                return err
+            }
+
+            payload := struct {
+                Results []RegisteredDirectory
+                Next string
+            }{}
+
+            dec := json.NewDecoder(resp.Body)
+            err = dec.Decode(&payload)
+            if err != nil {
+                return err
+            }
+
+            if len(payload.Results) > 0 {
+                output = append(output, (payload.Results)...)
+            }
+
+            // The next URL may be relative to the SewerRat URL.
+            target = ""
+            if payload.Next != "" {
+                next, err := url.Parse(payload.Next)
+                if err != nil {
+                    return fmt.Errorf("failed to parse the next URL %q; %w", payload.Next, err)
+                }
+                target = base.ResolveReference(next).String()
+            }
+            return nil
+        }()
+
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return output, nil
+}
+
+// Lists all registered directories, following the pagination until all results are obtained.
+func (c *Client) ListRegisteredDirectories(ctx context.Context, options *ListOptions) ([]RegisteredDirectory, error) {
+    query := url.Values{}
+    if options != nil {
+        if options.WithinPath != "" {
+            query.Set("within_path", options.WithinPath)
+        }
+        if options.Exists != nil {
+            query.Set("exists", fmt.Sprint(*(options.Exists)))
+        }
+    }
+
+    target := c.URL + "/registered"
+    if len(query) > 0 {
+        target += "?" + query.Encode()
+    }
+    dir := ""
+    if options != nil {
+        dir = options.WithinPath
+    }
+    return c.listRegisteredDirectoriesRaw(ctx, dir, target)
+}
+
+// Lists all registered subdirectories of 'dir', returning their paths relative to 'dir'.
+func (c *Client) ListRegisteredSubdirectories(ctx context.Context, dir string) ([]string, error) {
+    output, err := c.ListRegisteredDirectories(ctx, &ListOptions{ WithinPath: dir })
+    if err != nil {
+        return nil, fmt.Errorf("failed to list subdirectories of %q; %w", dir, err)
+    }
+    collected := []string{}
+    for _, val := range output {
+        rel, err := filepath.Rel(dir, val.Path)
+        if err == nil && filepath.IsLocal(rel) {
+            collected = append(collected, rel)
+        }
+    }
+    return collected, nil
+}
+
+func (c *Client) registerDirectory(ctx context.Context, dir string, names []string, action string) error {
+    register := (action != ActionDeregister)
+    if c.DryRun != nil {
+        if !register {
+            names = nil
+        }
+        c.DryRun.add(Action{ Type: action, Path: dir, Names: names })
+        return nil
+    }
+
+    err := c.registerDirectoryRaw(ctx, dir, names, register)
+    if c.OnAction != nil {
+        c.OnAction(action, dir, err)
+    }
+    if err == nil {
+        c.log(slog.LevelDebug, "SewerRat action", "action", action, "path", dir)
+    }
+    return err
+}
+
+func (c *Client) registerDirectoryRaw(ctx context.Context, dir string, names []string, register bool) error {
+    endpt := "register"
+    msg := "registration"
+    if !register {
+        endpt = "deregister"
+        msg = "deregistration"
+    }
+
+    {
+        payload := map[string]interface{}{ "path": dir }
+        resp, err := c.postJson(ctx, "/" + endpt + "/start", dir, payload)
+        if err != nil {
+            return fmt.Errorf("failed to initialize %s for %q; %w", msg, dir, err)
+        }
+        defer resp.Body.Close()
+
+        if resp.StatusCode >= 300 {
+            err := parseFailure(resp, "/" + endpt + "/start", dir)
+            c.log(slog.LevelWarn, "failed to initialize " + msg, "endpoint", err.Endpoint, "path", dir, "status", resp.StatusCode, "error", err.Reason)
+            return fmt.Errorf("failed to initialize %s for %q; %w", msg, dir, err)
+        }
+
+        decoded := struct {
+            Code string `json:"code"`
+            Status string `json:"status"`
+        }{}
+        dec := json.NewDecoder(resp.Body)
+        err = dec.Decode(&decoded)
+        if err != nil {
+            return fmt.Errorf("failed to parse initialization response for %q; %w", dir, err)
+        }
+
+        if !register && decoded.Status == "SUCCESS" {
+            return nil
+        }
+
+        code_path := filepath.Join(dir, decoded.Code)
+        handle, err := os.OpenFile(code_path, os.O_CREATE, 0644)
+        if err != nil {
+            return fmt.Errorf("failed to create %s code in %q; %w", msg, dir, err)
+        }
+        handle.Close()
+        defer os.Remove(code_path)
+    }
+
+    {
+        payload := map[string]interface{}{ "path": dir }
+        if register && names != nil{
+            payload["base"] = names
+        }
+        resp, err := c.postJson(ctx, "/" + endpt + "/finish", dir, payload)
+        if err != nil {
+            return fmt.Errorf("failed to finish %s for %q; %w", msg, dir, err)
+        }
+        defer resp.Body.Close()
+
+        if resp.StatusCode >= 300 {
+            err := parseFailure(resp, "/" + endpt + "/finish", dir)
+            c.log(slog.LevelWarn, "failed to finish " + msg, "endpoint", err.Endpoint, "path", dir, "status", resp.StatusCode, "error", err.Reason)
+            return fmt.Errorf("failed to finish %s for %q; %w", msg, dir, err)
+        }
+    }
+
+    return nil
+}
+
+// Registers 'dir' with SewerRat, indexing all files with the specified 'names'.
+// If 'names' is nil, SewerRat's default names are used.
+func (c *Client) RegisterDirectory(ctx context.Context, dir string, names []string) error {
+    return c.registerDirectory(ctx, dir, names, ActionRegister)
+}
+
+// Reindexes 'dir', which should already be registered with SewerRat, using the specified 'names'.
+// This is the same as RegisterDirectory, except that it is reported as a separate action in dry-run mode.
+func (c *Client) ReindexDirectory(ctx context.Context, dir string, names []string) error {
+    return c.registerDirectory(ctx, dir, names, ActionReindex)
+}
+
+// Deregisters 'dir' from SewerRat.
+func (c *Client) DeregisterDirectory(ctx context.Context, dir string) error {
+    return c.registerDirectory(ctx, dir, nil, ActionDeregister)
+}
+
+func (c *Client) deregisterSubdirectoriesRaw(ctx context.Context, dir string, not_exists bool) error {
+    options := &ListOptions{ WithinPath: dir }
+    if not_exists {
+        exists := false
+        options.Exists = &exists
+    }
+    output, err := c.ListRegisteredDirectories(ctx, options)
+    if err != nil {
+        return fmt.Errorf("failed to list subdirectories of %q; %w", dir, err)
+    }
+    all_errors := []error{}
+    for _, val := range output {
+        if err := ctx.Err(); err != nil {
+            all_errors = append(all_errors, err)
+            break
+        }
+        err := c.DeregisterDirectory(ctx, val.Path)
+        all_errors = append(all_errors, err)
+    }
+    return errors.Join(all_errors...)
+}
+
+// Deregisters all registered subdirectories of 'dir'.
+func (c *Client) DeregisterAllSubdirectories(ctx context.Context, dir string) error {
+    return c.deregisterSubdirectoriesRaw(ctx, dir, false)
+}
+
+// Deregisters all registered subdirectories of 'dir' that no longer exist.
+func (c *Client) DeregisterMissingSubdirectories(ctx context.Context, dir string) error {
+    return c.deregisterSubdirectoriesRaw(ctx, dir, true)
+}
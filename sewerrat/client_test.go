@@ -0,0 +1,525 @@
+package sewerrat
+
+import (
+    "context"
+    "testing"
+    "os"
+    "sort"
+    "path/filepath"
+    "encoding/json"
+    "fmt"
+    "bytes"
+    "net/http"
+    "net/http/httptest"
+    "errors"
+    "strings"
+    "time"
+    "log/slog"
+    "github.com/ArtifactDB/sayoko/sewerrat/sewerrattest"
+)
+
+// URL of the SewerRat instance for testing, see sewerrattest.StartOrEnv() for details.
+var sewerratUrl string
+
+func TestMain(m *testing.M) {
+    url, stop := sewerrattest.StartOrEnv(3) // small pages to check that pagination is handled correctly.
+    sewerratUrl = url
+    code := m.Run()
+    stop()
+    os.Exit(code)
+}
+
+func getSewerRatUrl() string {
+    return sewerratUrl
+}
+
+func setupDirectories() ([]string, error) {
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        return nil, err
+    }
+
+    dir1 := filepath.Join(dir, "FOO")
+    err = os.Mkdir(dir1, 0755)
+    if err != nil {
+        return nil, err
+    }
+
+    dir2 := filepath.Join(dir, "BAR")
+    err = os.Mkdir(dir2, 0755)
+    if err != nil {
+        return nil, err
+    }
+
+    altdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        return nil, err
+    }
+
+    dir3 := filepath.Join(altdir, "WHEE")
+    err = os.Mkdir(dir3, 0755)
+    if err != nil {
+        return nil, err
+    }
+
+    client := NewClient(getSewerRatUrl())
+    ctx := context.Background()
+    names := []string{ "metadata.json" }
+    err = client.RegisterDirectory(ctx, dir1, names)
+    if err != nil {
+        return nil, err
+    }
+    err = client.RegisterDirectory(ctx, dir2, names)
+    if err != nil {
+        return nil, err
+    }
+    err = client.RegisterDirectory(ctx, dir3, names)
+    if err != nil {
+        return nil, err
+    }
+
+    return []string{ dir1, dir2, dir3 }, nil
+}
+
+func TestListRegisteredSubdirectories(t *testing.T) {
+    dirs, err := setupDirectories()
+    if err != nil {
+        t.Fatal(err)
+    }
+    dir1 := dirs[0]
+    dir3 := dirs[2]
+
+    client := NewClient(getSewerRatUrl())
+    ctx := context.Background()
+    found, err := client.ListRegisteredSubdirectories(ctx, filepath.Dir(dir1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    sort.Strings(found)
+    if len(found) != 2 || found[0] != "BAR" || found[1] != "FOO" {
+        t.Errorf("unexpected results from listing; %v", found)
+    }
+
+    found, err = client.ListRegisteredSubdirectories(ctx, filepath.Dir(dir3))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0] != "WHEE" { // dir1 is its own registered entity.
+        t.Errorf("unexpected results from listing; %v", found)
+    }
+}
+
+func TestDeregisterAllSubdirectories(t *testing.T) {
+    dirs, err := setupDirectories()
+    if err != nil {
+        t.Fatal(err)
+    }
+    dir1 := dirs[0]
+    dir3 := dirs[2]
+
+    client := NewClient(getSewerRatUrl())
+    ctx := context.Background()
+    err = client.DeregisterAllSubdirectories(ctx, filepath.Dir(dir1))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    found, err := client.ListRegisteredSubdirectories(ctx, filepath.Dir(dir1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) > 0 {
+        t.Errorf("all directories should have been deregistered; %v", found)
+    }
+
+    // Other subdirectories are still okay.
+    found, err = client.ListRegisteredSubdirectories(ctx, filepath.Dir(dir3))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0] != "WHEE" { // dir1 is its own registered entity.
+        t.Errorf("unexpected results from listing; %v", found)
+    }
+}
+
+func TestDeregisterMissingSubdirectories(t *testing.T) {
+    dirs, err := setupDirectories()
+    if err != nil {
+        t.Fatal(err)
+    }
+    dir1 := dirs[0]
+    dir2 := dirs[1]
+
+    err = os.RemoveAll(dir2)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := NewClient(getSewerRatUrl())
+    ctx := context.Background()
+    err = client.DeregisterMissingSubdirectories(ctx, filepath.Dir(dir1))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    found, err := client.ListRegisteredSubdirectories(ctx, filepath.Dir(dir1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0] != "FOO" {
+        t.Errorf("only missing directories should have been deregistered; %v", found)
+    }
+}
+
+func TestClientDryRun(t *testing.T) {
+    dirs, err := setupDirectories()
+    if err != nil {
+        t.Fatal(err)
+    }
+    dir1 := dirs[0]
+    dir2 := dirs[1]
+
+    err = os.RemoveAll(dir2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    dir4 := filepath.Join(filepath.Dir(dir1), "STUFF")
+    err = os.Mkdir(dir4, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := NewClient(getSewerRatUrl())
+    client.DryRun = &Plan{}
+    ctx := context.Background()
+    names := []string{ "metadata.json" }
+
+    err = client.DeregisterMissingSubdirectories(ctx, filepath.Dir(dir1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = client.RegisterDirectory(ctx, dir4, names)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = client.ReindexDirectory(ctx, dir1, names)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = client.DeregisterDirectory(ctx, dir2) // duplicate actions are ignored.
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    actions := client.DryRun.Actions()
+    if len(actions) != 3 ||
+        actions[0].Type != ActionDeregister || actions[0].Path != dir2 ||
+        actions[1].Type != ActionRegister || actions[1].Path != dir4 || len(actions[1].Names) != 1 ||
+        actions[2].Type != ActionReindex || actions[2].Path != dir1 {
+        t.Errorf("unexpected actions in the plan; %v", actions)
+    }
+
+    // Nothing was actually changed.
+    found, err := client.ListRegisteredSubdirectories(ctx, filepath.Dir(dir1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    sort.Strings(found)
+    if len(found) != 2 || found[0] != "BAR" || found[1] != "FOO" {
+        t.Errorf("dry run should not modify the registered directories; %v", found)
+    }
+}
+
+func TestClientHooks(t *testing.T) {
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := NewClient(getSewerRatUrl())
+    requests := []string{}
+    client.OnRequest = func(endpoint string, status int, latency time.Duration) {
+        requests = append(requests, endpoint)
+        if status < 200 || status >= 300 {
+            t.Errorf("unexpected status %d for %q", status, endpoint)
+        }
+        if latency <= 0 {
+            t.Errorf("expected a positive latency for %q", endpoint)
+        }
+    }
+    actions := []string{}
+    client.OnAction = func(action string, path string, err error) {
+        actions = append(actions, fmt.Sprintf("%s %s %v", action, path, err == nil))
+    }
+
+    ctx := context.Background()
+    err = client.RegisterDirectory(ctx, dir, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = client.ListRegisteredSubdirectories(ctx, dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = client.DeregisterDirectory(ctx, dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(requests) != 5 || requests[0] != "/register/start" || requests[1] != "/register/finish" || requests[2] != "/registered" || requests[3] != "/deregister/start" || requests[4] != "/deregister/finish" {
+        t.Errorf("unexpected requests; %v", requests)
+    }
+    if len(actions) != 2 || actions[0] != "register " + dir + " true" || actions[1] != "deregister " + dir + " true" {
+        t.Errorf("unexpected actions; %v", actions)
+    }
+
+    // Failed actions are also reported.
+    client.OnRequest = nil
+    actions = actions[:0]
+    err = client.RegisterDirectory(ctx, filepath.Join(dir, "missing"), nil)
+    if err == nil {
+        t.Error("expected a failure for a missing directory")
+    }
+    if len(actions) != 1 || !strings.HasSuffix(actions[0], "false") {
+        t.Errorf("unexpected actions; %v", actions)
+    }
+}
+
+func TestRegisterDirectoryNames(t *testing.T) {
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = os.WriteFile(filepath.Join(dir, "foo.json"), []byte("{ \"first\": \"athena\", \"last\": \"glory\", \"show\": \"aria the animation\" }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(dir, "metadata.json"), []byte("{ \"series\": \"aria\", \"season\": 3 }"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := NewClient(getSewerRatUrl())
+    ctx := context.Background()
+    defer client.DeregisterDirectory(ctx, dir) // to avoid affecting other tests.
+
+    querySewerRat := func(query string) ([]string, error) {
+        b, err := json.Marshal(map[string]interface{}{ "type": "text", "text": query })
+        if err != nil {
+            return nil, fmt.Errorf("failed to create query request body; %w", err)
+        }
+
+        r := bytes.NewReader(b)
+        resp, err := http.Post(client.URL + "/query", "application/json", r)
+        if err != nil {
+            return nil, fmt.Errorf("failed to request query; %w", err)
+        }
+        defer resp.Body.Close()
+
+        if resp.StatusCode >= 300 {
+            err := parseFailure(resp, "/query", "")
+            return nil, fmt.Errorf("failed query; %w", err)
+        }
+
+        type queryHit struct {
+            Path string `json:"path"`
+        }
+        type allQueryHits struct {
+            Results []queryHit `json:"results"`
+        }
+        dec := json.NewDecoder(resp.Body)
+        decoded := allQueryHits{}
+        err = dec.Decode(&decoded)
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse query response; %w", err) 
+        }
+
+        output := []string{}
+        for _, q := range decoded.Results {
+            output = append(output, q.Path)
+        }
+        return output, nil
+    }
+
+    err = client.RegisterDirectory(ctx, dir, []string{ "foo.json" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    output, err := querySewerRat("athena")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(output) != 1 || filepath.Base(output[0]) != "foo.json" {
+        t.Errorf("unexpected query result when registering foo.json; %v", output)
+    }
+
+    err = client.RegisterDirectory(ctx, dir, []string{ "foo.json", "metadata.json" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    output, err = querySewerRat("aria")
+    if err != nil {
+        t.Fatal(err)
+    }
+    sort.Strings(output)
+    if len(output) != 2 || filepath.Base(output[0]) != "foo.json" || filepath.Base(output[1]) != "metadata.json" {
+        t.Errorf("unexpected query result when registering both foo.json and metadata.json; %v", output)
+    }
+}
+
+func TestListRegisteredDirectoriesOptions(t *testing.T) {
+    dirs, err := setupDirectories()
+    if err != nil {
+        t.Fatal(err)
+    }
+    dir1 := dirs[0]
+    dir2 := dirs[1]
+
+    err = os.RemoveAll(dir2)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client := NewClient(getSewerRatUrl())
+    ctx := context.Background()
+    parent := filepath.Dir(dir1)
+
+    found, err := client.ListRegisteredDirectories(ctx, &ListOptions{ WithinPath: parent })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 2 {
+        t.Errorf("unexpected results from listing; %v", found)
+    }
+
+    exists := false
+    found, err = client.ListRegisteredDirectories(ctx, &ListOptions{ WithinPath: parent, Exists: &exists })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0].Path != dir2 {
+        t.Errorf("unexpected results from listing missing directories; %v", found)
+    }
+
+    exists = true
+    found, err = client.ListRegisteredDirectories(ctx, &ListOptions{ WithinPath: parent, Exists: &exists })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0].Path != dir1 {
+        t.Errorf("unexpected results from listing existing directories; %v", found)
+    }
+}
+
+func TestClientConfiguration(t *testing.T) {
+    var agent string
+    var queries []string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        agent = r.Header.Get("User-Agent")
+        queries = append(queries, r.URL.RawQuery)
+        w.Header().Set("Content-Type", "application/json")
+        if r.URL.Query().Get("start") == "" {
+            w.Write([]byte(`{ "results": [ { "path": "/foo" } ], "next": "/registered?start=1" }`)) // relative URL for the next page.
+        } else {
+            w.Write([]byte(`{ "results": [ { "path": "/bar" } ] }`))
+        }
+    }))
+    defer server.Close()
+
+    client := NewClient(server.URL)
+    client.UserAgent = "foobar"
+    found, err := client.ListRegisteredDirectories(context.Background(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 2 || found[0].Path != "/foo" || found[1].Path != "/bar" {
+        t.Errorf("unexpected results from paginated listing; %v", found)
+    }
+    if agent != "foobar" {
+        t.Errorf("unexpected user agent %q", agent)
+    }
+    if len(queries) != 2 || queries[0] != "" || queries[1] != "start=1" {
+        t.Errorf("unexpected queries %v", queries)
+    }
+}
+
+func TestClientCancellation(t *testing.T) {
+    release := make(chan bool)
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        <-release
+    }))
+    defer server.Close()
+    defer close(release)
+
+    // Respects the context.
+    client := NewClient(server.URL)
+    ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+    defer cancel()
+    _, err := client.ListRegisteredDirectories(ctx, nil)
+    if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("expected a deadline error; %v", err)
+    }
+
+    // Respects the client's timeout.
+    client.HTTPClient.Timeout = 100 * time.Millisecond
+    err = client.RegisterDirectory(context.Background(), "/foo", nil)
+    if err == nil || !strings.Contains(err.Error(), "Timeout") {
+        t.Errorf("expected a timeout error; %v", err)
+    }
+}
+
+func TestClientPing(t *testing.T) {
+    client := NewClient(getSewerRatUrl())
+    err := client.Ping(context.Background())
+    if err != nil {
+        t.Error(err)
+    }
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }))
+    defer server.Close()
+    client = NewClient(server.URL)
+    err = client.Ping(context.Background())
+    if err == nil || !strings.Contains(err.Error(), "503") {
+        t.Errorf("expected a failure for a server error; %v", err)
+    }
+
+    server.Close()
+    err = client.Ping(context.Background())
+    if err == nil || !strings.Contains(err.Error(), "failed to reach") {
+        t.Errorf("expected a failure for an unreachable server; %v", err)
+    }
+}
+
+func TestClientLogger(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusInternalServerError)
+        w.Write([]byte(`{ "status": "ERROR", "reason": "whee" }`))
+    }))
+    defer server.Close()
+
+    var buf bytes.Buffer
+    client := NewClient(server.URL)
+    client.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ Level: slog.LevelDebug }))
+
+    err := client.RegisterDirectory(context.Background(), "/foo/bar", nil)
+    if err == nil {
+        t.Fatal("expected a registration failure")
+    }
+
+    lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+    if len(lines) != 2 {
+        t.Fatalf("unexpected number of log messages; %s", buf.String())
+    }
+    decoded := map[string]interface{}{}
+    err = json.Unmarshal([]byte(lines[1]), &decoded)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if decoded["level"] != "WARN" || decoded["endpoint"] != "/register/start" || decoded["path"] != "/foo/bar" || decoded["status"] != float64(500) || decoded["error"] != "whee" {
+        t.Errorf("unexpected log message; %s", lines[1])
+    }
+}
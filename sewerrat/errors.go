@@ -0,0 +1,78 @@
+package sewerrat
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+)
+
+var (
+    // No response was received from SewerRat, e.g., due to network problems or timeouts.
+    ErrUnreachable = errors.New("SewerRat is unreachable")
+
+    // SewerRat rejected the request with a 4xx status code.
+    ErrRejected = errors.New("request was rejected by SewerRat")
+
+    // SewerRat responded with a 404 status code.
+    // Such errors also match ErrRejected.
+    ErrNotFound = errors.New("not found by SewerRat")
+
+    // SewerRat responded with a 5xx status code.
+    ErrServer = errors.New("internal error in SewerRat")
+)
+
+// An Error is a failed request to the SewerRat API.
+// This can be compared to the sentinel errors (e.g., ErrNotFound) with errors.Is.
+type Error struct {
+    // Status code of the response, or zero if no response was received.
+    StatusCode int
+
+    // Endpoint of the request, e.g., "/register/start".
+    Endpoint string
+
+    // Path to the directory in the request, if any.
+    Path string
+
+    // Reason for the failure, as reported by SewerRat or inferred from the response.
+    Reason string
+
+    // Underlying cause of the failure, if any, e.g., the network error for unreachable requests.
+    Err error
+}
+
+func (e *Error) Error() string {
+    if e.StatusCode == 0 {
+        return fmt.Sprintf("failed to reach SewerRat at %s; %s", e.Endpoint, e.Reason)
+    }
+    return fmt.Sprintf("SewerRat responded with %d at %s; %s", e.StatusCode, e.Endpoint, e.Reason)
+}
+
+func (e *Error) Unwrap() error {
+    return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+    switch target {
+    case ErrUnreachable:
+        return e.StatusCode == 0
+    case ErrRejected:
+        return e.StatusCode >= 400 && e.StatusCode < 500
+    case ErrNotFound:
+        return e.StatusCode == http.StatusNotFound
+    case ErrServer:
+        return e.StatusCode >= 500
+    }
+    return false
+}
+
+// Whether the failure is permanent, i.e., repeating the same request is not expected to succeed.
+// This is true for all 4xx status codes other than timeouts (408) and rate limiting (429).
+func (e *Error) Permanent() bool {
+    return e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
+// Whether 'err' is (or wraps) a permanent SewerRat failure, see Error.Permanent.
+func IsPermanent(err error) bool {
+    var serr *Error
+    return errors.As(err, &serr) && serr.Permanent()
+}
@@ -0,0 +1,82 @@
+package sewerrat
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestErrorIs(t *testing.T) {
+    notfound := &Error{ StatusCode: 404, Endpoint: "/registered", Reason: "whee" }
+    if !errors.Is(notfound, ErrNotFound) || !errors.Is(notfound, ErrRejected) || errors.Is(notfound, ErrServer) || errors.Is(notfound, ErrUnreachable) {
+        t.Error("unexpected sentinel matches for a 404")
+    }
+    if !notfound.Permanent() {
+        t.Error("expected a 404 to be permanent")
+    }
+
+    server := &Error{ StatusCode: 503, Endpoint: "/registered", Reason: "whee" }
+    if !errors.Is(server, ErrServer) || errors.Is(server, ErrRejected) || server.Permanent() {
+        t.Error("unexpected classification of a 503")
+    }
+
+    limited := &Error{ StatusCode: 429, Endpoint: "/registered", Reason: "whee" }
+    if !errors.Is(limited, ErrRejected) || limited.Permanent() {
+        t.Error("unexpected classification of a 429")
+    }
+
+    unreachable := &Error{ Endpoint: "/registered", Reason: "whee", Err: context.Canceled }
+    if !errors.Is(unreachable, ErrUnreachable) || !errors.Is(unreachable, context.Canceled) || unreachable.Permanent() {
+        t.Error("unexpected classification of an unreachable request")
+    }
+
+    wrapped := errors.Join(errors.New("foo"), notfound)
+    if !IsPermanent(wrapped) {
+        t.Error("expected permanent errors to be detected through wrapping")
+    }
+    if IsPermanent(errors.New("foo")) {
+        t.Error("expected other errors to not be permanent")
+    }
+}
+
+func TestClientErrors(t *testing.T) {
+    status := http.StatusNotFound
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(status)
+        w.Write([]byte(`{ "status": "ERROR", "reason": "oops" }`))
+    }))
+    defer server.Close()
+
+    client := NewClient(server.URL)
+    ctx := context.Background()
+
+    err := client.RegisterDirectory(ctx, "/foo/bar", []string{ "metadata.json" })
+    var serr *Error
+    if !errors.As(err, &serr) {
+        t.Fatalf("expected a SewerRat error; %v", err)
+    }
+    if serr.StatusCode != 404 || serr.Endpoint != "/register/start" || serr.Path != "/foo/bar" || serr.Reason != "oops" {
+        t.Errorf("unexpected contents of the error; %#v", serr)
+    }
+    if !errors.Is(err, ErrNotFound) || !IsPermanent(err) {
+        t.Error("expected a permanent not-found error")
+    }
+
+    status = http.StatusInternalServerError
+    _, err = client.ListRegisteredDirectories(ctx, &ListOptions{ WithinPath: "/foo" })
+    if !errors.As(err, &serr) || serr.Endpoint != "/registered" || serr.Path != "/foo" {
+        t.Fatalf("expected a SewerRat error for listing; %v", err)
+    }
+    if !errors.Is(err, ErrServer) || IsPermanent(err) {
+        t.Error("expected a transient server error")
+    }
+
+    server.Close()
+    err = client.DeregisterDirectory(ctx, "/foo/bar")
+    if !errors.Is(err, ErrUnreachable) || IsPermanent(err) {
+        t.Errorf("expected a transient unreachable error; %v", err)
+    }
+}
@@ -0,0 +1,67 @@
+package sewerrat
+
+import (
+    "sync"
+)
+
+const (
+    // Registration of a directory that is not yet registered.
+    ActionRegister = "register"
+
+    // Re-registration of an already-registered directory, forcing SewerRat to re-index its contents.
+    ActionReindex = "reindex"
+
+    // Deregistration of a directory.
+    ActionDeregister = "deregister"
+)
+
+// An Action is a registration or deregistration that would have been performed by a Client in dry-run mode.
+type Action struct {
+    // Type of action, i.e., one of 'ActionRegister', 'ActionReindex' or 'ActionDeregister'.
+    Type string `json:"action"`
+
+    // Path to the directory.
+    Path string `json:"path"`
+
+    // Names of the metadata files to be indexed, for registrations.
+    // This may be nil, in which case SewerRat's default names would be used.
+    Names []string `json:"names,omitempty"`
+}
+
+// A Plan records the actions that would have been performed by a Client in dry-run mode.
+// It is safe to use the same Plan in multiple goroutines.
+type Plan struct {
+    mutex sync.Mutex
+    actions []Action
+    recorded map[planKey]bool
+}
+
+type planKey struct {
+    Type string
+    Path string
+}
+
+func (p *Plan) add(action Action) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+
+    // Ignoring duplicate actions, e.g., when a directory is deregistered by multiple steps of a full scan.
+    key := planKey{ Type: action.Type, Path: action.Path }
+    if p.recorded == nil {
+        p.recorded = map[planKey]bool{}
+    }
+    if p.recorded[key] {
+        return
+    }
+    p.recorded[key] = true
+    p.actions = append(p.actions, action)
+}
+
+// Actions in the plan, in the order in which they were recorded.
+func (p *Plan) Actions() []Action {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    output := make([]Action, len(p.actions))
+    copy(output, p.actions)
+    return output
+}
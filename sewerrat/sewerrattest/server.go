@@ -0,0 +1,383 @@
+// Package sewerrattest provides an in-memory fake of the SewerRat API, for testing without a live SewerRat instance.
+//
+// The fake implements the subset of the API that is used by the sewerrat package, i.e.,
+// '/registered', '/register/start', '/register/finish', '/deregister/start', '/deregister/finish' and '/query'.
+// It also mimics SewerRat's verification process, where the client must create a file named after the supplied code in the directory to be (de)registered.
+package sewerrattest
+
+import (
+    "os"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "strings"
+    "strconv"
+    "math/rand"
+    "path/filepath"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+)
+
+// Default number of results in each page of the '/registered' endpoint.
+const DefaultPageSize = 100
+
+// Default names of the metadata files to be indexed, if none are supplied during registration.
+var DefaultNames = []string{ "metadata.json" }
+
+type registration struct {
+    Names []string
+    Time time.Time
+
+    // Mapping of each token to the metadata files containing that token.
+    Tokens map[string][]string
+}
+
+// A Server is a fake SewerRat instance, listening on a local loopback address.
+type Server struct {
+    *httptest.Server
+
+    // Maximum number of results in each page of the '/registered' endpoint.
+    // This should be set before any requests are made.
+    PageSize int
+
+    lock sync.Mutex
+    registered map[string]*registration
+    pending map[string]string
+    counts map[string]int
+}
+
+// Starts a new fake SewerRat instance with no registered directories.
+// Callers should call Close() when finished to shut it down.
+func NewServer() *Server {
+    s := &Server{
+        PageSize: DefaultPageSize,
+        registered: map[string]*registration{},
+        pending: map[string]string{},
+        counts: map[string]int{},
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /{$}", s.handleRoot)
+    mux.HandleFunc("GET /registered", s.handleRegistered)
+    mux.HandleFunc("POST /register/start", s.handleStart(true))
+    mux.HandleFunc("POST /register/finish", s.handleFinish(true))
+    mux.HandleFunc("POST /deregister/start", s.handleStart(false))
+    mux.HandleFunc("POST /deregister/finish", s.handleFinish(false))
+    mux.HandleFunc("POST /query", s.handleQuery)
+
+    s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        s.lock.Lock()
+        s.counts[r.URL.Path] += 1
+        s.lock.Unlock()
+        mux.ServeHTTP(w, r)
+    }))
+    return s
+}
+
+// Provides a SewerRat instance for testing, returning its URL and a function to shut it down.
+// If the SEWERRAT_URL environment variable is set, the live instance at that URL is used and the returned function does nothing.
+// Otherwise, a fake instance is started with the specified page size for the '/registered' endpoint.
+func StartOrEnv(pageSize int) (string, func()) {
+    url := os.Getenv("SEWERRAT_URL")
+    if url != "" {
+        return url, func() {}
+    }
+    server := NewServer()
+    server.PageSize = pageSize
+    return server.URL, server.Close
+}
+
+// Paths of all registered directories, sorted in lexicographic order.
+func (s *Server) Registered() []string {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    output := make([]string, 0, len(s.registered))
+    for p := range s.registered {
+        output = append(output, p)
+    }
+    sort.Strings(output)
+    return output
+}
+
+// Number of requests that have been made to 'endpoint' (e.g., "/registered"), including failed requests.
+// If 'endpoint' is empty, the total number of requests to all endpoints is returned.
+func (s *Server) Requests(endpoint string) int {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if endpoint != "" {
+        return s.counts[endpoint]
+    }
+    total := 0
+    for _, count := range s.counts {
+        total += count
+    }
+    return total
+}
+
+func writeJson(w http.ResponseWriter, status int, payload interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, status int, reason string) {
+    writeJson(w, status, map[string]string{ "status": "ERROR", "reason": reason })
+}
+
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+    writeJson(w, http.StatusOK, map[string]string{ "name": "SewerRat API", "url": "https://github.com/ArtifactDB/SewerRat" })
+}
+
+func isWithinPath(dir string, within string) bool {
+    if within == "" || dir == within {
+        return true
+    }
+    return strings.HasPrefix(dir, strings.TrimSuffix(within, "/") + "/")
+}
+
+func (s *Server) handleRegistered(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    within := query.Get("within_path")
+
+    var exists *bool
+    if val := query.Get("exists"); val != "" {
+        parsed, err := strconv.ParseBool(val)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'exists' value %q", val))
+            return
+        }
+        exists = &parsed
+    }
+
+    start := 0
+    if val := query.Get("start"); val != "" {
+        parsed, err := strconv.Atoi(val)
+        if err != nil || parsed < 0 {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'start' value %q", val))
+            return
+        }
+        start = parsed
+    }
+
+    type registeredResult struct {
+        Path string `json:"path"`
+        Names []string `json:"names"`
+        Time int64 `json:"time"`
+    }
+    all := []registeredResult{}
+
+    s.lock.Lock()
+    for p, reg := range s.registered {
+        if !isWithinPath(p, within) {
+            continue
+        }
+        all = append(all, registeredResult{ Path: p, Names: reg.Names, Time: reg.Time.Unix() })
+    }
+    s.lock.Unlock()
+
+    if exists != nil {
+        filtered := []registeredResult{}
+        for _, res := range all {
+            _, err := os.Stat(res.Path)
+            if (err == nil) == *exists {
+                filtered = append(filtered, res)
+            }
+        }
+        all = filtered
+    }
+
+    sort.Slice(all, func(i, j int) bool { return all[i].Path < all[j].Path })
+    start = min(start, len(all))
+    end := min(start + s.PageSize, len(all))
+
+    output := map[string]interface{}{ "results": all[start:end] }
+    if end < len(all) {
+        query.Set("start", strconv.Itoa(end))
+        output["next"] = "/registered?" + query.Encode()
+    }
+    writeJson(w, http.StatusOK, output)
+}
+
+func (s *Server) handleStart(register bool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        payload := struct {
+            Path string `json:"path"`
+        }{}
+        err := json.NewDecoder(r.Body).Decode(&payload)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request body; %v", err))
+            return
+        }
+        if !filepath.IsAbs(payload.Path) {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("path %q should be absolute", payload.Path))
+            return
+        }
+
+        info, err := os.Stat(payload.Path)
+        if err != nil {
+            // Like SewerRat, deregistration of a missing directory does not require verification.
+            if !register {
+                s.lock.Lock()
+                delete(s.registered, payload.Path)
+                s.lock.Unlock()
+                writeJson(w, http.StatusOK, map[string]string{ "status": "SUCCESS" })
+                return
+            }
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("path %q does not exist", payload.Path))
+            return
+        }
+        if !info.IsDir() {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("path %q is not a directory", payload.Path))
+            return
+        }
+
+        code := fmt.Sprintf(".sewer_%d", rand.Int63())
+        s.lock.Lock()
+        s.pending[payload.Path] = code
+        s.lock.Unlock()
+        writeJson(w, http.StatusAccepted, map[string]string{ "status": "PENDING", "code": code })
+    }
+}
+
+func (s *Server) handleFinish(register bool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        payload := struct {
+            Path string `json:"path"`
+            Base []string `json:"base"`
+        }{}
+        err := json.NewDecoder(r.Body).Decode(&payload)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request body; %v", err))
+            return
+        }
+
+        s.lock.Lock()
+        code, found := s.pending[payload.Path]
+        s.lock.Unlock()
+        if !found {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("no pending verification for %q", payload.Path))
+            return
+        }
+        if _, err := os.Stat(filepath.Join(payload.Path, code)); err != nil {
+            writeError(w, http.StatusUnauthorized, fmt.Sprintf("failed to verify the code in %q", payload.Path))
+            return
+        }
+
+        var reg *registration
+        if register {
+            names := payload.Base
+            if len(names) == 0 {
+                names = DefaultNames
+            }
+            reg = &registration{ Names: names, Time: time.Now(), Tokens: indexDirectory(payload.Path, names) }
+        }
+
+        s.lock.Lock()
+        delete(s.pending, payload.Path)
+        if register {
+            s.registered[payload.Path] = reg
+        } else {
+            delete(s.registered, payload.Path)
+        }
+        s.lock.Unlock()
+        writeJson(w, http.StatusOK, map[string]string{ "status": "SUCCESS" })
+    }
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+    payload := struct {
+        Type string `json:"type"`
+        Text string `json:"text"`
+    }{}
+    err := json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request body; %v", err))
+        return
+    }
+    if payload.Type != "text" {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported query type %q", payload.Type))
+        return
+    }
+
+    // All tokens must be present in a file for it to be reported.
+    tokens := map[string]bool{}
+    tokenize(payload.Text, tokens)
+
+    type queryResult struct {
+        Path string `json:"path"`
+    }
+    results := []queryResult{}
+
+    s.lock.Lock()
+    for _, reg := range s.registered {
+        counts := map[string]int{}
+        for tok := range tokens {
+            for _, p := range reg.Tokens[tok] {
+                counts[p] += 1
+            }
+        }
+        for p, n := range counts {
+            if n == len(tokens) {
+                results = append(results, queryResult{ Path: p })
+            }
+        }
+    }
+    s.lock.Unlock()
+
+    sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+    writeJson(w, http.StatusOK, map[string]interface{}{ "results": results })
+}
+
+func isTokenSeparator(r rune) bool {
+    return !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'))
+}
+
+// Collects the lower-cased alphanumeric tokens from all strings in a parsed JSON value.
+func tokenize(value interface{}, tokens map[string]bool) {
+    switch x := value.(type) {
+    case string:
+        for _, tok := range strings.FieldsFunc(strings.ToLower(x), isTokenSeparator) {
+            tokens[tok] = true
+        }
+    case map[string]interface{}:
+        for _, y := range x {
+            tokenize(y, tokens)
+        }
+    case []interface{}:
+        for _, y := range x {
+            tokenize(y, tokens)
+        }
+    }
+}
+
+// Indexes all JSON files in 'dir' (and its subdirectories) with any of the 'names', ignoring files that cannot be read or parsed.
+func indexDirectory(dir string, names []string) map[string][]string {
+    wanted := map[string]bool{}
+    for _, n := range names {
+        wanted[n] = true
+    }
+
+    output := map[string][]string{}
+    filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+        if err != nil || d.IsDir() || !wanted[d.Name()] {
+            return nil
+        }
+        contents, err := os.ReadFile(path)
+        if err != nil {
+            return nil
+        }
+        var parsed interface{}
+        if json.Unmarshal(contents, &parsed) != nil {
+            return nil
+        }
+        tokens := map[string]bool{}
+        tokenize(parsed, tokens)
+        for tok := range tokens {
+            output[tok] = append(output[tok], path)
+        }
+        return nil
+    })
+    return output
+}
@@ -0,0 +1,172 @@
+package sewerrattest
+
+import (
+    "os"
+    "bytes"
+    "strings"
+    "testing"
+    "net/http"
+    "path/filepath"
+    "encoding/json"
+)
+
+func postJson(t *testing.T, target string, payload interface{}) (int, map[string]interface{}) {
+    b, err := json.Marshal(payload)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp, err := http.Post(target, "application/json", bytes.NewReader(b))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    output := map[string]interface{}{}
+    err = json.NewDecoder(resp.Body).Decode(&output)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return resp.StatusCode, output
+}
+
+func TestServerRegistration(t *testing.T) {
+    server := NewServer()
+    defer server.Close()
+
+    dir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    status, body := postJson(t, server.URL + "/register/start", map[string]string{ "path": dir })
+    if status != http.StatusAccepted {
+        t.Fatalf("unexpected response from starting registration; %d %v", status, body)
+    }
+    code := body["code"].(string)
+
+    // Finishing fails without the verification code.
+    status, _ = postJson(t, server.URL + "/register/finish", map[string]string{ "path": dir })
+    if status != http.StatusUnauthorized {
+        t.Errorf("expected a failure to verify the code; %d", status)
+    }
+
+    err = os.WriteFile(filepath.Join(dir, code), []byte{}, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    status, _ = postJson(t, server.URL + "/register/finish", map[string]string{ "path": dir })
+    if status != http.StatusOK {
+        t.Errorf("expected registration to succeed; %d", status)
+    }
+    if registered := server.Registered(); len(registered) != 1 || registered[0] != dir {
+        t.Errorf("expected the directory to be registered; %v", registered)
+    }
+
+    // Missing directories can be deregistered without verification.
+    status, body = postJson(t, server.URL + "/register/start", map[string]string{ "path": filepath.Join(dir, "missing") })
+    if status != http.StatusBadRequest || !strings.Contains(body["reason"].(string), "does not exist") {
+        t.Errorf("expected a failure to register a missing directory; %d %v", status, body)
+    }
+
+    err = os.RemoveAll(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    status, body = postJson(t, server.URL + "/deregister/start", map[string]string{ "path": dir })
+    if status != http.StatusOK || body["status"] != "SUCCESS" {
+        t.Errorf("expected immediate deregistration of a missing directory; %d %v", status, body)
+    }
+    if registered := server.Registered(); len(registered) != 0 {
+        t.Errorf("expected the directory to be deregistered; %v", registered)
+    }
+
+    if server.Requests("/register/start") != 2 || server.Requests("/register/finish") != 2 {
+        t.Errorf("unexpected number of requests; %d %d", server.Requests("/register/start"), server.Requests("/register/finish"))
+    }
+    if server.Requests("") != 5 {
+        t.Errorf("unexpected total number of requests; %d", server.Requests(""))
+    }
+}
+
+func TestServerRegisteredPagination(t *testing.T) {
+    server := NewServer()
+    server.PageSize = 2
+    defer server.Close()
+
+    parent, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, name := range []string{ "a", "b", "c", "d", "e" } {
+        server.registered[filepath.Join(parent, name)] = &registration{}
+    }
+    server.registered["/elsewhere"] = &registration{}
+
+    collected := []string{}
+    pages := 0
+    next := "/registered?within_path=" + parent
+    for next != "" {
+        resp, err := http.Get(server.URL + next)
+        if err != nil {
+            t.Fatal(err)
+        }
+        payload := struct {
+            Results []struct { Path string `json:"path"` } `json:"results"`
+            Next string `json:"next"`
+        }{}
+        err = json.NewDecoder(resp.Body).Decode(&payload)
+        resp.Body.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, res := range payload.Results {
+            collected = append(collected, filepath.Base(res.Path))
+        }
+        next = payload.Next
+        pages += 1
+    }
+
+    if pages != 3 || strings.Join(collected, ",") != "a,b,c,d,e" {
+        t.Errorf("unexpected results from pagination; %d %v", pages, collected)
+    }
+
+    err = os.Mkdir(filepath.Join(parent, "c"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp, err := http.Get(server.URL + "/registered?exists=true&within_path=" + parent)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    payload := struct {
+        Results []struct { Path string `json:"path"` } `json:"results"`
+    }{}
+    err = json.NewDecoder(resp.Body).Decode(&payload)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(payload.Results) != 1 || filepath.Base(payload.Results[0].Path) != "c" {
+        t.Errorf("unexpected results when filtering on existence; %v", payload.Results)
+    }
+}
+
+func TestStartOrEnv(t *testing.T) {
+    t.Setenv("SEWERRAT_URL", "")
+    url, stop := StartOrEnv(5)
+    resp, err := http.Get(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    stop()
+    if resp.StatusCode != http.StatusOK {
+        t.Errorf("expected the fake instance to be running; %d", resp.StatusCode)
+    }
+
+    t.Setenv("SEWERRAT_URL", "http://localhost:12345")
+    url, stop = StartOrEnv(5)
+    defer stop()
+    if url != "http://localhost:12345" {
+        t.Errorf("expected the URL to be taken from the environment; %q", url)
+    }
+}
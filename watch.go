@@ -0,0 +1,57 @@
+package main
+
+import (
+    "log/slog"
+    "time"
+    "fmt"
+    "github.com/fsnotify/fsnotify"
+)
+
+// Watches the log directory for new or modified files, sending a signal through 'trigger' once the directory has been quiet for 'delay'.
+// The delay ensures that we don't read log files before the Gobbler has finished writing them.
+// The returned function should be called to stop watching.
+//
+// Note that some filesystems (e.g., NFS) do not deliver events for changes made on other machines,
+// so callers should continue to poll the directory at regular intervals.
+func watchLogDirectory(log_dir string, delay time.Duration, trigger chan<- bool) (func(), error) {
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        return nil, fmt.Errorf("failed to create a watcher for %q; %w", log_dir, err)
+    }
+
+    err = watcher.Add(log_dir)
+    if err != nil {
+        watcher.Close()
+        return nil, fmt.Errorf("failed to watch %q; %w", log_dir, err)
+    }
+
+    go func() {
+        timer := time.NewTimer(delay)
+        timer.Stop()
+        for {
+            select {
+            case event, ok := <-watcher.Events:
+                if !ok {
+                    timer.Stop()
+                    return
+                }
+                if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
+                    timer.Reset(delay)
+                }
+            case err, ok := <-watcher.Errors:
+                if !ok {
+                    timer.Stop()
+                    return
+                }
+                slog.Warn("failed to watch the log directory", "error", err)
+            case <-timer.C:
+                select {
+                case trigger <- true:
+                default: // a previous trigger is still pending, so there's no need to send another.
+                }
+            }
+        }
+    }()
+
+    return func() { watcher.Close() }, nil
+}
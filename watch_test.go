@@ -0,0 +1,54 @@
+package main
+
+import (
+    "testing"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+func TestWatchLogDirectory(t *testing.T) {
+    logdir, err := os.MkdirTemp("", "")
+    if err != nil {
+        t.Fatalf("failed to create log directory; %v", err)
+    }
+
+    trigger := make(chan bool, 1)
+    stop, err := watchLogDirectory(logdir, 100 * time.Millisecond, trigger)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer stop()
+
+    // No triggers without any changes.
+    select {
+    case <-trigger:
+        t.Fatal("unexpected trigger without any new logs")
+    case <-time.After(300 * time.Millisecond):
+    }
+
+    // Multiple files in quick succession should only generate one trigger.
+    for _, name := range []string{ "2022-02-22T02:22:22Z_111111", "2022-02-22T02:22:22Z_222222" } {
+        err = os.WriteFile(filepath.Join(logdir, name), []byte("{ \"type\": \"add-version\", \"project\": \"foo\", \"asset\": \"bar\" }"), 0644)
+        if err != nil {
+            t.Fatalf("failed to create a new log file; %v", err)
+        }
+    }
+
+    select {
+    case <-trigger:
+    case <-time.After(5 * time.Second):
+        t.Fatal("expected a trigger after adding new logs")
+    }
+
+    select {
+    case <-trigger:
+        t.Fatal("unexpected second trigger")
+    case <-time.After(300 * time.Millisecond):
+    }
+
+    _, err = watchLogDirectory(filepath.Join(logdir, "missing"), time.Second, trigger)
+    if err == nil {
+        t.Error("expected an error when watching a missing directory")
+    }
+}